@@ -1,13 +1,9 @@
 package anonimize
 
 import (
-	"bytes"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"strings"
-	"teamacedia/discord-bot/internal/config"
-	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -30,63 +26,69 @@ func OnMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) bool {
 		return false
 	}
 
-	// Only handle anon channel
-	if m.ChannelID != config.Config.AnonChannelID {
+	// Only handle anon channels and their threads
+	ch, threadID := resolveChannel(s, m.ChannelID)
+	if ch == nil {
 		return false
 	}
 
-	id, token, err := SplitWebhookURL(config.Config.AnonWebhook)
-	if err != nil {
-		return false
+	if reason := checkMessage(m, ch.ChannelID, ch.Filter); reason != "" {
+		reject(s, m, reason)
+		return true
 	}
 
 	content := strings.TrimSpace(m.Content)
 
-	// Handle replies or forwards
+	// Handle replies or forwards, leaving out the quoted snippet if the message would get too long
 	if m.MessageReference != nil && m.MessageReference.MessageID != "" {
-		// Create a link to the replied message
-		link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", m.GuildID, m.MessageReference.ChannelID, m.MessageReference.MessageID)
-		content = fmt.Sprintf("Reply > %s\n%s", link, content)
+		quote := replyQuote(s, m, len([]rune(content)))
+		content = quote + truncate(content, contentLimit-len([]rune(quote)))
+	} else {
+		content = truncate(content, contentLimit)
 	}
 
 	params := &discordgo.WebhookParams{
-		Content:   content,
-		Username:  m.Author.DisplayName(),
-		AvatarURL: m.Author.AvatarURL(""),
+		Content: content,
 	}
+	ch.applyIdentity(params, m.Author)
 
-	// Download attachments before deletion
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	for _, a := range m.Attachments {
-		resp, err := client.Get(a.URL)
-		if err != nil {
-			params.Content += "\n" + a.URL
-			continue
-		}
-		data, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil || len(data) == 0 {
-			params.Content += "\n" + a.URL
-			continue
-		}
-
-		params.Files = append(params.Files, &discordgo.File{
-			Name:   a.Filename,
-			Reader: bytes.NewReader(data),
-		})
+	// Download attachments before deletion, keeping the original if any can't be relayed
+	files, cleanup, err := downloadAttachments(m.Attachments, uploadLimit(s, m.GuildID))
+	if err != nil {
+		notifyNotRelayed(s, m, err.Error()+".")
+		return false
 	}
+	defer cleanup()
+	params.Files = files
 
 	if params.Content == "" && len(params.Files) == 0 {
 		return false
 	}
 
-	if err := s.ChannelMessageDelete(m.ChannelID, m.ID); err != nil {
+	// Repost first so a failed webhook call never loses the message
+	msg, err := ch.execute(s, threadID, params)
+	if err != nil {
+		log.Printf("Failed to relay anon message %s: %v", m.ID, err)
+		notifyNotRelayed(s, m, "the message could not be reposted.")
 		return false
 	}
 
-	if _, err := s.WebhookExecute(id, token, false, params); err != nil {
-		return false
+	if err := s.ChannelMessageDelete(m.ChannelID, m.ID); err != nil {
+		log.Printf("Failed to delete original anon message %s: %v", m.ID, err)
+
+		// The anonymous copy is up, but the original still shows who wrote it
+		dmAuthor(s, m.Author.ID, &discordgo.MessageEmbed{
+			Title: "Original Message Not Deleted",
+			Description: fmt.Sprintf("Your message in <#%s> was posted anonymously, but the original with your name "+
+				"could not be deleted. Delete it yourself to stay anonymous: https://discord.com/channels/%s/%s/%s",
+				m.ChannelID, m.GuildID, m.ChannelID, m.ID),
+			Color: 0xff8800,
+		})
+	}
+
+	// Keep an encrypted record of the author so moderators can audit abuse
+	if err := recordMessage(msg.ID, msg.ChannelID, m.Author.ID); err != nil {
+		log.Printf("Failed to record author of anon message %s: %v", msg.ID, err)
 	}
 
 	return true
@@ -0,0 +1,141 @@
+package anonimize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const downloadTimeout = 2 * time.Minute
+
+// uploadLimit returns the largest upload the guild accepts in a single message
+func uploadLimit(s *discordgo.Session, guildID string) int64 {
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		guild, err = s.Guild(guildID)
+		if err != nil {
+			return 10 * 1024 * 1024
+		}
+	}
+
+	switch guild.PremiumTier {
+	case discordgo.PremiumTier3:
+		return 100 * 1024 * 1024
+	case discordgo.PremiumTier2:
+		return 50 * 1024 * 1024
+	default:
+		return 10 * 1024 * 1024
+	}
+}
+
+// downloadAttachments streams every attachment into a temporary file, concurrently.
+// If any attachment can't be fetched within limit, all files are cleaned up and an error is returned.
+// On success the caller must call the returned cleanup function once the files have been sent.
+func downloadAttachments(attachments []*discordgo.MessageAttachment, limit int64) ([]*discordgo.File, func(), error) {
+	var total int64
+	for _, a := range attachments {
+		total += int64(a.Size)
+	}
+	if total > limit {
+		return nil, func() {}, fmt.Errorf("the attachments add up to %.1f MB, more than the %d MB this server allows", float64(total)/1024/1024, limit/1024/1024)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout)
+	defer cancel()
+
+	files := make([]*os.File, len(attachments))
+	errs := make([]error, len(attachments))
+
+	var wg sync.WaitGroup
+	for idx, a := range attachments {
+		wg.Add(1)
+		go func(idx int, a *discordgo.MessageAttachment) {
+			defer wg.Done()
+			files[idx], errs[idx] = downloadAttachment(ctx, a, limit)
+			if errs[idx] != nil {
+				cancel() // no point finishing the others
+			}
+		}(idx, a)
+	}
+	wg.Wait()
+
+	cleanup := func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}
+
+	// Report the download that actually failed rather than one we cancelled because of it
+	failed := -1
+	for idx, err := range errs {
+		if err != nil && (failed == -1 || !errors.Is(err, context.Canceled)) {
+			failed = idx
+		}
+	}
+	if failed != -1 {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("attachment `%s` could not be relayed: %w", attachments[failed].Filename, errs[failed])
+	}
+
+	result := make([]*discordgo.File, len(attachments))
+	for idx, a := range attachments {
+		result[idx] = &discordgo.File{
+			Name:        a.Filename,
+			ContentType: a.ContentType,
+			Reader:      files[idx],
+		}
+	}
+	return result, cleanup, nil
+}
+
+// downloadAttachment streams a single attachment into a temporary file rewound to its start
+func downloadAttachment(ctx context.Context, a *discordgo.MessageAttachment, limit int64) (*os.File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed with status %s", resp.Status)
+	}
+
+	f, err := os.CreateTemp("", "anon-attachment-*")
+	if err != nil {
+		return nil, err
+	}
+
+	// Read one byte past the limit so oversized files are detected without buffering them
+	n, err := io.Copy(f, io.LimitReader(resp.Body, limit+1))
+	if err == nil && n > limit {
+		err = fmt.Errorf("file is larger than the %d MB upload limit", limit/1024/1024)
+	}
+	if err == nil && n == 0 {
+		err = fmt.Errorf("file is empty")
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
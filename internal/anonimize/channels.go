@@ -0,0 +1,186 @@
+package anonimize
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const webhookName = "Anonymous"
+
+// channel is a configured anonymous channel together with the webhook used to repost in it
+type channel struct {
+	models.AnonChannel
+
+	mu        sync.Mutex
+	webhookID string
+	token     string
+}
+
+var channels = make(map[string]*channel)
+
+// Init prepares a webhook for every configured anonymous channel, creating one where needed.
+// Channels whose webhook can't be set up are skipped and left as normal channels.
+func Init(s *discordgo.Session) {
+	for _, cfg := range config.Config.AnonChannels {
+		ch := &channel{AnonChannel: cfg}
+
+		if cfg.WebhookURL != "" {
+			id, token, err := SplitWebhookURL(cfg.WebhookURL)
+			if err != nil {
+				log.Printf("Invalid webhook for anon channel %s: %v", cfg.ChannelID, err)
+				continue
+			}
+			ch.webhookID, ch.token = id, token
+		} else if err := ch.loadWebhook(s); err != nil {
+			log.Printf("Failed to set up webhook for anon channel %s: %v", cfg.ChannelID, err)
+			continue
+		}
+
+		channels[cfg.ChannelID] = ch
+		log.Printf("Anonymous channel %s ready in %s mode", cfg.ChannelID, cfg.Mode)
+	}
+}
+
+// channelFor returns the anonymous channel with the given ID, or nil if it isn't one
+func channelFor(channelID string) *channel {
+	return channels[channelID]
+}
+
+// resolveChannel returns the anonymous channel a message channel belongs to, and the thread ID
+// if the message channel is a thread (or forum post) inside an anonymous channel
+func resolveChannel(s *discordgo.Session, channelID string) (*channel, string) {
+	if ch := channelFor(channelID); ch != nil {
+		return ch, ""
+	}
+
+	c, err := s.State.Channel(channelID)
+	if err != nil {
+		c, err = s.Channel(channelID)
+		if err != nil {
+			return nil, ""
+		}
+	}
+
+	if !c.IsThread() {
+		return nil, ""
+	}
+	if ch := channelFor(c.ParentID); ch != nil {
+		return ch, c.ID
+	}
+	return nil, ""
+}
+
+// withThreadID targets a webhook message request at a thread of the webhook's channel
+func withThreadID(threadID string) discordgo.RequestOption {
+	return func(cfg *discordgo.RequestConfig) {
+		if threadID == "" {
+			return
+		}
+		query := cfg.Request.URL.Query()
+		query.Set("thread_id", threadID)
+		cfg.Request.URL.RawQuery = query.Encode()
+	}
+}
+
+// loadWebhook reuses the stored webhook if it still exists, otherwise creates a new one
+func (ch *channel) loadWebhook(s *discordgo.Session) error {
+	stored, err := db.GetAnonWebhook(ch.ChannelID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if err == nil {
+		if _, err := s.WebhookWithToken(stored.WebhookID, stored.Token); err == nil {
+			ch.webhookID, ch.token = stored.WebhookID, stored.Token
+			return nil
+		}
+		log.Printf("Stored webhook for anon channel %s is gone, creating a new one", ch.ChannelID)
+	}
+
+	return ch.createWebhook(s)
+}
+
+// createWebhook creates a fresh webhook in the channel and stores it
+func (ch *channel) createWebhook(s *discordgo.Session) error {
+	webhook, err := s.WebhookCreate(ch.ChannelID, webhookName, "")
+	if err != nil {
+		return err
+	}
+
+	ch.webhookID, ch.token = webhook.ID, webhook.Token
+	return db.SetAnonWebhook(models.AnonWebhook{
+		ChannelID: ch.ChannelID,
+		WebhookID: webhook.ID,
+		Token:     webhook.Token,
+	})
+}
+
+// webhook returns the current webhook ID and token
+func (ch *channel) webhook() (string, string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.webhookID, ch.token
+}
+
+// execute posts through the channel's webhook, into threadID if set, recreating a managed
+// webhook once if it was deleted
+func (ch *channel) execute(s *discordgo.Session, threadID string, params *discordgo.WebhookParams) (*discordgo.Message, error) {
+	id, token := ch.webhook()
+	msg, err := s.WebhookThreadExecute(id, token, true, threadID, params)
+	if err == nil || ch.WebhookURL != "" || !isUnknownWebhook(err) {
+		return msg, err
+	}
+
+	// Another message may have recreated the webhook already, then just retry with it
+	err = nil
+	ch.mu.Lock()
+	if ch.webhookID == id {
+		log.Printf("Webhook for anon channel %s was deleted, recreating it", ch.ChannelID)
+		err = ch.createWebhook(s)
+	}
+	ch.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// The first attempt consumed the attachments, rewind them for the retry
+	for _, f := range params.Files {
+		if seeker, ok := f.Reader.(io.Seeker); ok {
+			seeker.Seek(0, io.SeekStart)
+		}
+	}
+
+	id, token = ch.webhook()
+	return s.WebhookThreadExecute(id, token, true, threadID, params)
+}
+
+// isUnknownWebhook reports whether a REST error means the webhook no longer exists
+func isUnknownWebhook(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) {
+		return false
+	}
+	return restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound
+}
+
+// applyIdentity sets the name and avatar the repost is shown with according to the channel mode
+func (ch *channel) applyIdentity(params *discordgo.WebhookParams, author *discordgo.User) {
+	switch ch.Mode {
+	case models.AnonModeNamed:
+		params.Username = author.DisplayName()
+		params.AvatarURL = author.AvatarURL("")
+	case models.AnonModePseudonym:
+		params.Username, params.AvatarURL = pseudonym(ch.ChannelID, ch.PseudonymScheme, author.ID)
+	default:
+		params.Username = "Anonymous"
+	}
+}
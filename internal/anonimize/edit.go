@@ -0,0 +1,99 @@
+package anonimize
+
+import (
+	"database/sql"
+	"errors"
+	"teamacedia/discord-bot/internal/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	ErrNotAnonMessage = errors.New("this is not an anonymous message on record")
+	ErrNotAuthor      = errors.New("you are not the author of this message")
+)
+
+// VerifyAuthor returns an error unless userID wrote the reposted message
+func VerifyAuthor(userID, messageID string) error {
+	_, err := verifiedMessage(userID, messageID)
+	return err
+}
+
+// verifiedMessage returns the stored mapping of a reposted message if userID wrote it
+func verifiedMessage(userID, messageID string) (models.AnonMessage, error) {
+	msg, authorID, err := RevealAuthor(messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return msg, ErrNotAnonMessage
+	}
+	if err != nil {
+		return msg, err
+	}
+	if authorID != userID {
+		return msg, ErrNotAuthor
+	}
+	return msg, nil
+}
+
+// authoredChannel checks that userID wrote the reposted message and returns the anonymous
+// channel it was posted in, plus its thread ID if it was posted in a thread
+func authoredChannel(s *discordgo.Session, userID, messageID string) (*channel, string, error) {
+	msg, err := verifiedMessage(userID, messageID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ch, threadID := resolveChannel(s, msg.ChannelID)
+	if ch == nil {
+		return nil, "", errors.New("this message's channel is no longer anonymous")
+	}
+	return ch, threadID, nil
+}
+
+// EditMessage replaces the content of an anonymous message if userID is its original author.
+// The reply quote of the message is kept, so it can't be changed or forged by editing.
+func EditMessage(s *discordgo.Session, userID, messageID, content string) error {
+	ch, threadID, err := authoredChannel(s, userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	// Edits go through the same checks as new messages, so banned users can't rewrite old ones
+	if reason := checkBan(userID); reason != "" {
+		return errors.New(reason)
+	}
+	if reason := checkContent(content, ch.Filter); reason != "" {
+		return errors.New(reason)
+	}
+	if reason := checkRate(ch.ChannelID, userID, ch.Filter); reason != "" {
+		return errors.New(reason)
+	}
+
+	channelID := ch.ChannelID
+	if threadID != "" {
+		channelID = threadID
+	}
+	current, err := s.ChannelMessage(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	quote, _ := SplitQuote(current.Content)
+	content = quote + content
+
+	id, token := ch.webhook()
+	_, err = s.WebhookMessageEdit(id, token, messageID, &discordgo.WebhookEdit{
+		Content: &content,
+	}, withThreadID(threadID))
+	return err
+}
+
+// DeleteMessage removes an anonymous message if userID is its original author.
+// The author mapping is kept so moderators can still audit reported messages.
+func DeleteMessage(s *discordgo.Session, userID, messageID string) error {
+	ch, threadID, err := authoredChannel(s, userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	id, token := ch.webhook()
+	return s.WebhookMessageDelete(id, token, messageID, withThreadID(threadID))
+}
@@ -0,0 +1,161 @@
+package anonimize
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	rateMu    sync.Mutex
+	sentTimes = make(map[string][]time.Time)
+)
+
+// allowRate records a message for the user in a channel and reports whether it fits in the rate limit window
+func allowRate(channelID, userID string, filter models.AnonFilter) bool {
+	if filter.RateLimit <= 0 {
+		return true
+	}
+
+	key := channelID + ":" + userID
+
+	rateMu.Lock()
+	defer rateMu.Unlock()
+
+	// Drop timestamps that fell out of the window
+	cutoff := time.Now().Add(-filter.RateWindow)
+	recent := slices.DeleteFunc(sentTimes[key], func(t time.Time) bool {
+		return t.Before(cutoff)
+	})
+
+	if len(recent) >= filter.RateLimit {
+		sentTimes[key] = recent
+		return false
+	}
+
+	sentTimes[key] = append(recent, time.Now())
+	return true
+}
+
+// checkContent returns a rejection reason if the text matches the blocklist
+func checkContent(content string, filter models.AnonFilter) string {
+	for _, re := range filter.Blocklist {
+		if re.MatchString(content) {
+			return "Your message contains blocked content."
+		}
+	}
+	return ""
+}
+
+// checkAttachment returns a rejection reason if the attachment type or size is not allowed
+func checkAttachment(a *discordgo.MessageAttachment, filter models.AnonFilter) string {
+	if len(filter.AllowedAttachments) > 0 {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(a.Filename)), ".")
+		if !slices.Contains(filter.AllowedAttachments, ext) {
+			return fmt.Sprintf("Attachment `%s` is not an allowed file type. Allowed types: %s.", a.Filename, strings.Join(filter.AllowedAttachments, ", "))
+		}
+	}
+
+	if filter.MaxAttachmentSize > 0 && int64(a.Size) > filter.MaxAttachmentSize {
+		return fmt.Sprintf("Attachment `%s` is larger than the %d MB limit.", a.Filename, filter.MaxAttachmentSize/1024/1024)
+	}
+
+	return ""
+}
+
+// checkMessage runs all abuse controls and returns a rejection reason, or "" if the message may be relayed
+func checkMessage(m *discordgo.MessageCreate, channelID string, filter models.AnonFilter) string {
+	if reason := checkBan(m.Author.ID); reason != "" {
+		return reason
+	}
+
+	if reason := checkContent(m.Content, filter); reason != "" {
+		return reason
+	}
+
+	for _, a := range m.Attachments {
+		if reason := checkAttachment(a, filter); reason != "" {
+			return reason
+		}
+	}
+
+	// Checked last so rejected messages don't count towards the limit
+	return checkRate(channelID, m.Author.ID, filter)
+}
+
+// checkBan returns why userID can't post anonymously, or "" if they aren't banned
+func checkBan(userID string) string {
+	ban, err := db.GetAnonBan(userID)
+	if err == nil {
+		if ban.ExpiresAt.IsZero() {
+			return "You are banned from anonymous channels."
+		}
+		return fmt.Sprintf("You are banned from anonymous channels until <t:%d:f>.", ban.ExpiresAt.Unix())
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("DB error while checking anon ban for %s: %v", userID, err)
+	}
+	return ""
+}
+
+// checkRate counts a message towards userID's rate limit and returns why it is refused, or "" if it is allowed
+func checkRate(channelID, userID string, filter models.AnonFilter) string {
+	if !allowRate(channelID, userID, filter) {
+		return fmt.Sprintf("You are sending anonymous messages too quickly. The limit is %d per %s.", filter.RateLimit, filter.RateWindow)
+	}
+	return ""
+}
+
+// reject deletes the original message and DMs it back to the author with the reason
+func reject(s *discordgo.Session, m *discordgo.MessageCreate, reason string) {
+	if err := s.ChannelMessageDelete(m.ChannelID, m.ID); err != nil {
+		log.Printf("Failed to delete rejected anon message %s: %v", m.ID, err)
+	}
+
+	// Embed descriptions are capped at 4096 characters, which long messages can exceed
+	description := reason
+	if m.Content != "" {
+		content := m.Content
+		if runes := []rune(content); len(runes) > 3500 {
+			content = string(runes[:3500]) + "…"
+		}
+		description += "\n\nYour message:\n" + content
+	}
+
+	dmAuthor(s, m.Author.ID, &discordgo.MessageEmbed{
+		Title:       "Anonymous Message Rejected",
+		Description: description,
+		Color:       0xff0000,
+	})
+}
+
+// notifyNotRelayed tells the author their message was left in place because it couldn't be relayed
+func notifyNotRelayed(s *discordgo.Session, m *discordgo.MessageCreate, reason string) {
+	dmAuthor(s, m.Author.ID, &discordgo.MessageEmbed{
+		Title: "Anonymous Message Not Relayed",
+		Description: fmt.Sprintf("Your message in <#%s> was not posted anonymously because %s\n\n"+
+			"The original is still visible with your name, delete it yourself if you don't want it public.", m.ChannelID, reason),
+		Color: 0xff8800,
+	})
+}
+
+// dmAuthor sends an embed to a user's DMs
+func dmAuthor(s *discordgo.Session, userID string, embed *discordgo.MessageEmbed) {
+	channel, err := s.UserChannelCreate(userID)
+	if err != nil {
+		log.Printf("Failed to open DM with %s: %v", userID, err)
+		return
+	}
+	if _, err := s.ChannelMessageSendEmbed(channel.ID, embed); err != nil {
+		log.Printf("Failed to DM %s: %v", userID, err)
+	}
+}
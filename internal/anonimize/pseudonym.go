@@ -0,0 +1,40 @@
+package anonimize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"teamacedia/discord-bot/internal/config"
+)
+
+var (
+	adjectives = []string{
+		"Amber", "Brave", "Calm", "Crimson", "Curious", "Dusky", "Eager", "Fuzzy",
+		"Gentle", "Golden", "Hidden", "Icy", "Jolly", "Lucky", "Misty", "Nimble",
+		"Quiet", "Rusty", "Silver", "Sleepy", "Swift", "Velvet", "Witty", "Zesty",
+	}
+	animals = []string{
+		"Badger", "Beaver", "Falcon", "Ferret", "Fox", "Gecko", "Heron", "Koala",
+		"Lynx", "Marmot", "Moose", "Newt", "Otter", "Owl", "Panda", "Puffin",
+		"Raven", "Seal", "Sparrow", "Stoat", "Tapir", "Walrus", "Wombat", "Yak",
+	}
+)
+
+// pseudonym derives a stable name and default avatar for a user in a channel.
+// It is keyed with AnonKey so the mapping can't be recomputed from public user IDs.
+func pseudonym(channelID, scheme, userID string) (string, string) {
+	mac := hmac.New(sha256.New, []byte(config.Config.AnonKey))
+	mac.Write([]byte(channelID + ":" + userID))
+	n := binary.BigEndian.Uint64(mac.Sum(nil))
+
+	avatar := fmt.Sprintf("https://cdn.discordapp.com/embed/avatars/%d.png", n%6)
+
+	if scheme == "numbers" {
+		return fmt.Sprintf("Anon #%04d", n%10000), avatar
+	}
+
+	adjective := adjectives[n%uint64(len(adjectives))]
+	animal := animals[(n/uint64(len(adjectives)))%uint64(len(animals))]
+	return adjective + " " + animal, avatar
+}
@@ -0,0 +1,88 @@
+package anonimize
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const quoteLength = 100
+
+// contentLimit is the most characters a webhook message may hold
+const contentLimit = 2000
+
+// replyQuote renders the message a reply points at as a quoted snippet with its author's shown name.
+// The snippet is left out when it wouldn't fit in front of a body of bodyLength characters.
+// Forwards and messages that can't be fetched fall back to a plain link.
+func replyQuote(s *discordgo.Session, m *discordgo.MessageCreate, bodyLength int) string {
+	ref := m.MessageReference
+	link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", m.GuildID, ref.ChannelID, ref.MessageID)
+
+	if ref.Type == discordgo.MessageReferenceTypeForward {
+		return fmt.Sprintf("Forwarded > %s\n", link)
+	}
+
+	target := m.ReferencedMessage
+	if target == nil {
+		var err error
+		target, err = s.ChannelMessage(ref.ChannelID, ref.MessageID)
+		if err != nil {
+			return fmt.Sprintf("Reply > %s\n", link)
+		}
+	}
+
+	// Webhook reposts already carry the pseudonym as their username
+	name := "Unknown"
+	if target.Author != nil {
+		name = target.Author.Username
+		if target.WebhookID == "" {
+			name = target.Author.DisplayName()
+		}
+	}
+
+	header := fmt.Sprintf("> Replying to **%s** ([jump](%s))\n", name, link)
+	quote := header + "> " + snippet(target) + "\n"
+	if len([]rune(quote))+bodyLength > contentLimit {
+		return header
+	}
+	return quote
+}
+
+// truncate shortens text to at most limit characters
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// quotePattern matches the reply quote or forward link replyQuote puts in front of a repost,
+// including the older "[replied to]" wording. Only the header is matched when a repost has no snippet.
+var quotePattern = regexp.MustCompile(`^(?:> [^\n]*\(https://discord\.com/channels/[\d/]+\)\)?\n(?:> [^\n]*\n)?|(?:Forwarded|Reply) > https://discord\.com/channels/[\d/]+\n)`)
+
+// SplitQuote separates the reply quote of a repost from what the author wrote
+func SplitQuote(content string) (quote, body string) {
+	quote = quotePattern.FindString(content)
+	return quote, content[len(quote):]
+}
+
+// snippet shortens a message to a single quotable line without pinging anyone
+func snippet(msg *discordgo.Message) string {
+	text := strings.Join(strings.Fields(msg.Content), " ")
+	if text == "" {
+		if len(msg.Attachments) > 0 || len(msg.Embeds) > 0 {
+			return "*attachment*"
+		}
+		return "*empty message*"
+	}
+
+	if runes := []rune(text); len(runes) > quoteLength {
+		text = string(runes[:quoteLength]) + "…"
+	}
+
+	// Break mentions so quoting a message doesn't ping its targets again
+	return strings.ReplaceAll(text, "@", "@\u200b")
+}
@@ -0,0 +1,100 @@
+package anonimize
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+	"time"
+)
+
+var ErrNoAnonKey = errors.New("AnonKey is not configured")
+
+// newAEAD builds an AES-GCM cipher from the configured AnonKey
+func newAEAD() (cipher.AEAD, error) {
+	if config.Config.AnonKey == "" {
+		return nil, ErrNoAnonKey
+	}
+
+	key := sha256.Sum256([]byte(config.Config.AnonKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAuthor encrypts a user ID so it can be stored at rest
+func sealAuthor(userID string) (string, error) {
+	aead, err := newAEAD()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(userID), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openAuthor decrypts a user ID sealed with sealAuthor
+func openAuthor(sealed string) (string, error) {
+	aead, err := newAEAD()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("sealed author is too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	userID, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt author: %w", err)
+	}
+	return string(userID), nil
+}
+
+// recordMessage stores the encrypted author of a reposted message
+func recordMessage(messageID, channelID, authorID string) error {
+	sealed, err := sealAuthor(authorID)
+	if err != nil {
+		return err
+	}
+
+	return db.AddAnonMessage(models.AnonMessage{
+		MessageID:    messageID,
+		ChannelID:    channelID,
+		SealedAuthor: sealed,
+		CreatedAt:    time.Now(),
+	})
+}
+
+// RevealAuthor returns the stored mapping and the decrypted author ID of a reposted message
+func RevealAuthor(messageID string) (models.AnonMessage, string, error) {
+	msg, err := db.GetAnonMessage(messageID)
+	if err != nil {
+		return msg, "", err
+	}
+
+	authorID, err := openAuthor(msg.SealedAuthor)
+	if err != nil {
+		return msg, "", err
+	}
+	return msg, authorID, nil
+}
@@ -2,8 +2,12 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"teamacedia/discord-bot/internal/models"
+	"time"
 
 	"gopkg.in/ini.v1"
 )
@@ -17,28 +21,83 @@ func LoadConfig(path string) (*models.Config, error) {
 		return nil, err
 	}
 
-	reactionRoles, err := ParseReactionRoles(cfgFile.Section("").Key("ReactionRoles").String())
+	// ReactionRoles is optional, panels can also be managed with /rolepanel
+	var reactionRoles []models.ReactionRole
+	if data := cfgFile.Section("").Key("ReactionRoles").String(); strings.TrimSpace(data) != "" {
+		reactionRoles, err = ParseReactionRoles(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	anonChannels, err := parseAnonChannels(cfgFile)
 	if err != nil {
 		return nil, err
 	}
+	// Authors are stored encrypted, without a key /anon reveal and editing or deleting own messages can't work
+	if len(anonChannels) > 0 && cfgFile.Section("").Key("AnonKey").String() == "" {
+		return nil, errors.New("AnonKey is required when anonymous channels are configured")
+	}
+
+	// Default roles go to new members right away unless a delay is set
+	var defaultRoleDelay time.Duration
+	if data := cfgFile.Section("").Key("DefaultRoleDelay").String(); strings.TrimSpace(data) != "" {
+		defaultRoleDelay, err = ParseDuration(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DefaultRoleDelay: %w", err)
+		}
+	}
+	defaultRoleIDs := ParseList(cfgFile.Section("").Key("DefaultRoleIDs").String())
+	if len(defaultRoleIDs) == 0 && cfgFile.Section("").Key("MemberRoleID").String() != "" {
+		defaultRoleIDs = []string{cfgFile.Section("").Key("MemberRoleID").String()}
+	}
+
+	// Verification is off unless a channel is set
+	verificationCaptcha := cfgFile.Section("").Key("VerificationCaptcha").In(models.CaptchaImage, []string{models.CaptchaImage, models.CaptchaText})
+	var verificationTimeout time.Duration
+	if data := cfgFile.Section("").Key("VerificationTimeout").String(); strings.TrimSpace(data) != "" {
+		verificationTimeout, err = ParseDuration(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VerificationTimeout: %w", err)
+		}
+	}
 
 	cfg := &models.Config{
-		Token:                  cfgFile.Section("").Key("Token").String(),
-		AppID:                  cfgFile.Section("").Key("AppID").String(),
-		GuildID:                cfgFile.Section("").Key("GuildID").String(),
-		ReactionRolesChannelID: cfgFile.Section("").Key("ReactionRolesChannelID").String(),
-		LogChannelID:           cfgFile.Section("").Key("LogChannelID").String(),
-		MemberRoleID:           cfgFile.Section("").Key("MemberRoleID").String(),
-		ReactionRoles:          reactionRoles,
-		AnonWebhook:            cfgFile.Section("").Key("AnonWebhook").String(),
-		AnonChannelID:          cfgFile.Section("").Key("AnonChannelID").String(),
+		Token:                   cfgFile.Section("").Key("Token").String(),
+		AppID:                   cfgFile.Section("").Key("AppID").String(),
+		GuildID:                 cfgFile.Section("").Key("GuildID").String(),
+		ReactionRolesChannelID:  cfgFile.Section("").Key("ReactionRolesChannelID").String(),
+		LogChannelID:            cfgFile.Section("").Key("LogChannelID").String(),
+		MemberRoleID:            cfgFile.Section("").Key("MemberRoleID").String(),
+		ReactionRoles:           reactionRoles,
+		AnonChannels:            anonChannels,
+		AnonKey:                 cfgFile.Section("").Key("AnonKey").String(),
+		AdminRoleID:             cfgFile.Section("").Key("AdminRoleID").String(),
+		StickyAllowRoleIDs:      ParseList(cfgFile.Section("").Key("StickyRolesAllow").String()),
+		StickyDenyRoleIDs:       ParseList(cfgFile.Section("").Key("StickyRolesDeny").String()),
+		StickyModerationRoleIDs: ParseList(cfgFile.Section("").Key("StickyRolesModeration").String()),
+		StickyRetentionDays:     cfgFile.Section("").Key("StickyRetentionDays").MustInt(0),
+		DefaultRoleIDs:          defaultRoleIDs,
+		DefaultBotRoleIDs:       ParseList(cfgFile.Section("").Key("DefaultBotRoleIDs").String()),
+		DefaultRoleDelay:        defaultRoleDelay,
+		VerificationChannelID:   cfgFile.Section("").Key("VerificationChannelID").String(),
+		VerificationCaptcha:     verificationCaptcha,
+		VerificationTimeout:     verificationTimeout,
+		RulesChannelID:          cfgFile.Section("").Key("RulesChannelID").String(),
+		WelcomeChannelID:        cfgFile.Section("").Key("WelcomeChannelID").String(),
+		WelcomeMessage:          cfgFile.Section("").Key("WelcomeMessage").MustString("Welcome to **{server}**, {user}!"),
+		WelcomeDM:               cfgFile.Section("").Key("WelcomeDM").String(),
+		WelcomeBanner:           cfgFile.Section("").Key("WelcomeBanner").MustBool(false),
+		GoodbyeChannelID:        cfgFile.Section("").Key("GoodbyeChannelID").String(),
+		GoodbyeMessage:          cfgFile.Section("").Key("GoodbyeMessage").MustString("**{username}** left the server."),
 	}
 
 	return cfg, nil
 }
 
 // ParseReactionRoles parses a |-delimited string of reaction roles.
-// Format: ROLEID,ROLENAME,ROLEEMOJI|ROLEID2,ROLENAME2,ROLEEMOJI2|...
+// Format: ROLEID,ROLENAME,ROLEEMOJI[,DURATION]|ROLEID2,ROLENAME2,ROLEEMOJI2|...
+// Roles with a DURATION are removed again that long after they were picked.
 func ParseReactionRoles(data string) ([]models.ReactionRole, error) {
 	if strings.TrimSpace(data) == "" {
 		return nil, errors.New("input string is empty")
@@ -54,8 +113,8 @@ func ParseReactionRoles(data string) ([]models.ReactionRole, error) {
 		}
 
 		fields := strings.Split(part, ",")
-		if len(fields) != 3 {
-			return nil, errors.New("invalid format: expected ROLEID,ROLENAME,ROLEEMOJI")
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, errors.New("invalid format: expected ROLEID,ROLENAME,ROLEEMOJI[,DURATION]")
 		}
 
 		id := strings.TrimSpace(fields[0])
@@ -66,12 +125,198 @@ func ParseReactionRoles(data string) ([]models.ReactionRole, error) {
 			return nil, errors.New("invalid entry: one or more fields are empty")
 		}
 
+		parsed, err := models.ParseEmoji(emoji)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry for role %s: %w", name, err)
+		}
+
+		var duration time.Duration
+		if len(fields) == 4 {
+			duration, err = ParseDuration(fields[3])
+			if err != nil || duration <= 0 {
+				return nil, fmt.Errorf("invalid duration for role %s: %q", name, strings.TrimSpace(fields[3]))
+			}
+		}
+
 		roles = append(roles, models.ReactionRole{
-			ID:    id,
-			Name:  name,
-			Emoji: emoji,
+			ID:       id,
+			Name:     name,
+			Emoji:    parsed,
+			Duration: duration,
 		})
 	}
 
 	return roles, nil
 }
+
+// parseAnonChannels reads every anonymous channel from the config.
+// Each channel lives in its own [Anon.NAME] section, and the legacy top-level
+// AnonChannelID/AnonWebhook pair is still accepted as a channel in named mode.
+// Top-level Anon* filter keys act as defaults for every channel.
+func parseAnonChannels(cfgFile *ini.File) ([]models.AnonChannel, error) {
+	root := cfgFile.Section("")
+
+	defaults, err := parseAnonFilter(root, "Anon", models.AnonFilter{RateWindow: time.Minute})
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []models.AnonChannel
+
+	if channelID := root.Key("AnonChannelID").String(); channelID != "" {
+		channels = append(channels, models.AnonChannel{
+			ChannelID:  channelID,
+			WebhookURL: root.Key("AnonWebhook").String(),
+			Mode:       models.AnonModeNamed,
+			Filter:     defaults,
+		})
+	}
+
+	for _, section := range cfgFile.Sections() {
+		if !strings.HasPrefix(section.Name(), "Anon.") {
+			continue
+		}
+
+		filter, err := parseAnonFilter(section, "", defaults)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", section.Name(), err)
+		}
+
+		channel := models.AnonChannel{
+			ChannelID:       section.Key("ChannelID").String(),
+			WebhookURL:      section.Key("Webhook").String(),
+			Mode:            section.Key("Mode").MustString(models.AnonModeAnonymous),
+			PseudonymScheme: section.Key("PseudonymScheme").MustString("animals"),
+			Filter:          filter,
+		}
+
+		if channel.ChannelID == "" {
+			return nil, fmt.Errorf("[%s]: ChannelID is required", section.Name())
+		}
+		switch channel.Mode {
+		case models.AnonModeNamed, models.AnonModeAnonymous:
+		case models.AnonModePseudonym:
+			if root.Key("AnonKey").String() == "" {
+				return nil, fmt.Errorf("[%s]: pseudonym mode requires AnonKey to be set", section.Name())
+			}
+			if channel.PseudonymScheme != "animals" && channel.PseudonymScheme != "numbers" {
+				return nil, fmt.Errorf("[%s]: unknown PseudonymScheme %q, expected animals or numbers", section.Name(), channel.PseudonymScheme)
+			}
+		default:
+			return nil, fmt.Errorf("[%s]: unknown Mode %q, expected named, anonymous or pseudonym", section.Name(), channel.Mode)
+		}
+
+		channels = append(channels, channel)
+	}
+
+	return channels, nil
+}
+
+// parseAnonFilter reads the anonymous channel abuse controls from an INI section.
+// Keys are looked up with the given prefix and fall back to defaults when missing.
+func parseAnonFilter(section *ini.Section, prefix string, defaults models.AnonFilter) (models.AnonFilter, error) {
+	filter := defaults
+	var err error
+
+	filter.RateLimit = section.Key(prefix + "RateLimit").MustInt(defaults.RateLimit)
+
+	if section.HasKey(prefix + "RateWindow") {
+		filter.RateWindow, err = ParseDuration(section.Key(prefix + "RateWindow").String())
+		if err != nil {
+			return filter, fmt.Errorf("invalid %sRateWindow: %w", prefix, err)
+		}
+	}
+
+	if section.HasKey(prefix + "Blocklist") {
+		filter.Blocklist, err = ParseBlocklist(section.Key(prefix + "Blocklist").String())
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	if section.HasKey(prefix + "AllowedAttachments") {
+		filter.AllowedAttachments = ParseList(strings.ToLower(section.Key(prefix + "AllowedAttachments").String()))
+	}
+
+	if section.HasKey(prefix + "MaxAttachmentMB") {
+		filter.MaxAttachmentSize = section.Key(prefix+"MaxAttachmentMB").MustInt64(0) * 1024 * 1024
+	}
+
+	return filter, nil
+}
+
+// ParseList parses a comma-delimited string into a list of trimmed, non-empty values.
+func ParseList(data string) []string {
+	var values []string
+	for _, part := range strings.Split(data, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// ParseBlocklist parses a |-delimited list of blocked words and regular expressions.
+// Format: word|another phrase|re:REGEX|...
+// Words match case-insensitively on word boundaries, entries prefixed with re: are used as-is
+// and therefore cannot contain a | themselves.
+func ParseBlocklist(data string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+
+	for _, part := range strings.Split(data, "|") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		// Word boundaries only work next to word characters, so entries like @everyone or c++ still match
+		expr := regexp.QuoteMeta(part)
+		if isWordChar(part[0]) {
+			expr = `\b` + expr
+		}
+		if isWordChar(part[len(part)-1]) {
+			expr += `\b`
+		}
+		expr = "(?i)" + expr
+		if after, ok := strings.CutPrefix(part, "re:"); ok {
+			expr = after
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocklist entry %q: %w", part, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	return patterns, nil
+}
+
+// isWordChar reports whether b counts as a word character for \b
+func isWordChar(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// ParseDuration parses a duration like time.ParseDuration, additionally accepting days (d) and weeks (w).
+// Examples: 90s, 30m, 12h, 7d, 2w
+func ParseDuration(data string) (time.Duration, error) {
+	data = strings.TrimSpace(data)
+	if data == "" {
+		return 0, errors.New("duration is empty")
+	}
+
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	if unit, ok := units[data[len(data)-1]]; ok {
+		n, err := strconv.Atoi(data[:len(data)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", data)
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	return time.ParseDuration(data)
+}
@@ -3,7 +3,10 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"strings"
 	"teamacedia/discord-bot/internal/models"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -30,15 +33,145 @@ func InitDB(path string) error {
 		text TEXT UNIQUE NOT NULL
 	);
 
+	CREATE TABLE IF NOT EXISTS anon_messages (
+		message_id TEXT PRIMARY KEY,
+		channel_id TEXT NOT NULL,
+		sealed_author TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS anon_bans (
+		user_id TEXT PRIMARY KEY,
+		banned_by TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		expires_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS anon_webhooks (
+		channel_id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		token TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS role_panels (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		channel_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		roles_hash TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS role_panel_roles (
+		panel_id INTEGER NOT NULL REFERENCES role_panels(id) ON DELETE CASCADE,
+		role_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		emoji TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (panel_id, role_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS temp_roles (
+		guild_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role_id TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, role_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS stuck_reactions (
+		message_id TEXT NOT NULL,
+		emoji TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		PRIMARY KEY (message_id, emoji, user_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS onboarding_queue (
+		user_id TEXT NOT NULL,
+		guild_id TEXT NOT NULL,
+		assign_at INTEGER NOT NULL,
+		awaiting_screening INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, guild_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS verifications (
+		user_id TEXT NOT NULL,
+		guild_id TEXT NOT NULL,
+		joined_at INTEGER NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, guild_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS role_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		role_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS role_events_created_at ON role_events (created_at);
+
+	CREATE TABLE IF NOT EXISTS role_panel_groups (
+		panel_id INTEGER NOT NULL REFERENCES role_panels(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		max_roles INTEGER NOT NULL,
+		PRIMARY KEY (panel_id, name)
+	);
+
 	`
 	_, err = DB.Exec(schema)
 	if err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
 
+	// Columns added after a table was first created
+	migrations := []struct{ table, column, definition string }{
+		{"role_panels", "title", "TEXT NOT NULL DEFAULT 'Reaction Roles'"},
+		{"role_panels", "description", "TEXT NOT NULL DEFAULT ''"},
+		{"role_panels", "color", "INTEGER NOT NULL DEFAULT 65535"},
+		{"role_panels", "style", "TEXT NOT NULL DEFAULT 'reactions'"},
+		{"role_panels", "max_roles", "INTEGER NOT NULL DEFAULT 0"},
+		{"role_panel_roles", "group_name", "TEXT NOT NULL DEFAULT ''"},
+		{"role_panel_roles", "required_roles", "TEXT NOT NULL DEFAULT ''"},
+		{"onboarding_queue", "departed", "INTEGER NOT NULL DEFAULT 0"},
+		{"verifications", "departed", "INTEGER NOT NULL DEFAULT 0"},
+		{"role_panel_roles", "blocked_roles", "TEXT NOT NULL DEFAULT ''"},
+		{"role_panel_roles", "duration", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, m := range migrations {
+		if err := addColumn(m.table, m.column, m.definition); err != nil {
+			return fmt.Errorf("failed to migrate %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
 	return nil
 }
 
+// addColumn adds a column to an existing table unless it is already there
+func addColumn(table, column, definition string) error {
+	rows, err := DB.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func AddReminder(reminder models.Reminder) error {
 	_, err := DB.Exec("INSERT INTO reminders (user_id, text) VALUES (?, ?)", reminder.UserID, reminder.Text)
 	return err
@@ -84,3 +217,559 @@ func GetUserReminders(userID string) ([]models.Reminder, error) {
 	}
 	return reminders, nil
 }
+
+func AddAnonMessage(msg models.AnonMessage) error {
+	_, err := DB.Exec(
+		"INSERT INTO anon_messages (message_id, channel_id, sealed_author, created_at) VALUES (?, ?, ?, ?)",
+		msg.MessageID, msg.ChannelID, msg.SealedAuthor, msg.CreatedAt.Unix(),
+	)
+	return err
+}
+
+// GetAnonMessage returns the stored mapping for a reposted message, or sql.ErrNoRows if there is none.
+func GetAnonMessage(messageID string) (models.AnonMessage, error) {
+	var msg models.AnonMessage
+	var createdAt int64
+	err := DB.QueryRow(
+		"SELECT message_id, channel_id, sealed_author, created_at FROM anon_messages WHERE message_id = ?",
+		messageID,
+	).Scan(&msg.MessageID, &msg.ChannelID, &msg.SealedAuthor, &createdAt)
+	if err != nil {
+		return msg, err
+	}
+	msg.CreatedAt = time.Unix(createdAt, 0)
+	return msg, nil
+}
+
+// AddAnonBan bans a user from anonymous channels, replacing any existing ban
+func AddAnonBan(ban models.AnonBan) error {
+	var expiresAt int64
+	if !ban.ExpiresAt.IsZero() {
+		expiresAt = ban.ExpiresAt.Unix()
+	}
+	_, err := DB.Exec(
+		"INSERT OR REPLACE INTO anon_bans (user_id, banned_by, reason, expires_at) VALUES (?, ?, ?, ?)",
+		ban.UserID, ban.BannedBy, ban.Reason, expiresAt,
+	)
+	return err
+}
+
+func DeleteAnonBan(userID string) error {
+	_, err := DB.Exec("DELETE FROM anon_bans WHERE user_id = ?", userID)
+	return err
+}
+
+// GetAnonBan returns the active ban for a user, or sql.ErrNoRows if they are not banned.
+// Expired bans are removed when they are looked up.
+func GetAnonBan(userID string) (models.AnonBan, error) {
+	var ban models.AnonBan
+	var expiresAt int64
+	err := DB.QueryRow(
+		"SELECT user_id, banned_by, reason, expires_at FROM anon_bans WHERE user_id = ?",
+		userID,
+	).Scan(&ban.UserID, &ban.BannedBy, &ban.Reason, &expiresAt)
+	if err != nil {
+		return ban, err
+	}
+
+	if expiresAt != 0 {
+		ban.ExpiresAt = time.Unix(expiresAt, 0)
+		if time.Now().After(ban.ExpiresAt) {
+			if err := DeleteAnonBan(userID); err != nil {
+				return ban, err
+			}
+			return ban, sql.ErrNoRows
+		}
+	}
+	return ban, nil
+}
+
+// SetAnonWebhook stores the webhook the bot manages for an anonymous channel
+func SetAnonWebhook(webhook models.AnonWebhook) error {
+	_, err := DB.Exec(
+		"INSERT OR REPLACE INTO anon_webhooks (channel_id, webhook_id, token) VALUES (?, ?, ?)",
+		webhook.ChannelID, webhook.WebhookID, webhook.Token,
+	)
+	return err
+}
+
+// GetAnonWebhook returns the managed webhook for a channel, or sql.ErrNoRows if there is none
+func GetAnonWebhook(channelID string) (models.AnonWebhook, error) {
+	var webhook models.AnonWebhook
+	err := DB.QueryRow(
+		"SELECT channel_id, webhook_id, token FROM anon_webhooks WHERE channel_id = ?",
+		channelID,
+	).Scan(&webhook.ChannelID, &webhook.WebhookID, &webhook.Token)
+	return webhook, err
+}
+
+const rolePanelColumns = "id, name, channel_id, message_id, roles_hash, title, description, color, style, max_roles"
+
+func scanRolePanel(row interface{ Scan(...any) error }) (models.RolePanel, error) {
+	var panel models.RolePanel
+	err := row.Scan(&panel.ID, &panel.Name, &panel.ChannelID, &panel.MessageID, &panel.RolesHash, &panel.Title, &panel.Description, &panel.Color, &panel.Style, &panel.MaxRoles)
+	return panel, err
+}
+
+// GetRolePanel returns the panel with the given name and its roles, or sql.ErrNoRows if there is none
+func GetRolePanel(name string) (models.RolePanel, error) {
+	panel, err := scanRolePanel(DB.QueryRow("SELECT "+rolePanelColumns+" FROM role_panels WHERE name = ?", name))
+	if err != nil {
+		return panel, err
+	}
+
+	panel.Roles, err = getRolePanelRoles(panel.ID)
+	if err != nil {
+		return panel, err
+	}
+	panel.Groups, err = getRolePanelGroups(panel.ID)
+	return panel, err
+}
+
+// GetRolePanels returns every panel with its roles
+func GetRolePanels() ([]models.RolePanel, error) {
+	rows, err := DB.Query("SELECT " + rolePanelColumns + " FROM role_panels ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	var panels []models.RolePanel
+	for rows.Next() {
+		panel, err := scanRolePanel(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		panels = append(panels, panel)
+	}
+	rows.Close()
+
+	for i := range panels {
+		panels[i].Roles, err = getRolePanelRoles(panels[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		panels[i].Groups, err = getRolePanelGroups(panels[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return panels, nil
+}
+
+func getRolePanelRoles(panelID int64) ([]models.ReactionRole, error) {
+	rows, err := DB.Query("SELECT role_id, name, emoji, group_name, required_roles, blocked_roles, duration FROM role_panel_roles WHERE panel_id = ? ORDER BY position", panelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.ReactionRole
+	for rows.Next() {
+		var r models.ReactionRole
+		var emoji, required, blocked string
+		var duration int64
+		if err := rows.Scan(&r.ID, &r.Name, &emoji, &r.Group, &required, &blocked, &duration); err != nil {
+			return nil, err
+		}
+		r.Duration = time.Duration(duration) * time.Second
+		// Older versions stored emojis unchecked, skip those rather than failing to load every panel
+		r.Emoji, err = models.ParseEmoji(emoji)
+		if err != nil {
+			log.Printf("Skipping role %s on panel %d with unusable emoji: %v", r.ID, panelID, err)
+			continue
+		}
+		r.RequiredRoles = splitIDs(required)
+		r.BlockedRoles = splitIDs(blocked)
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+// splitIDs reads back a comma-separated ID list stored with strings.Join
+func splitIDs(data string) []string {
+	var ids []string
+	for _, id := range strings.Split(data, ",") {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func getRolePanelGroups(panelID int64) ([]models.RoleGroup, error) {
+	rows, err := DB.Query("SELECT name, max_roles FROM role_panel_groups WHERE panel_id = ? ORDER BY name", panelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.RoleGroup
+	for rows.Next() {
+		var g models.RoleGroup
+		if err := rows.Scan(&g.Name, &g.Max); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// SaveRolePanel creates or updates a panel by name and returns its ID. Roles are saved separately.
+func SaveRolePanel(panel models.RolePanel) (int64, error) {
+	var id int64
+	err := DB.QueryRow(
+		`INSERT INTO role_panels (name, channel_id, message_id, roles_hash, title, description, color, style, max_roles) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET channel_id = excluded.channel_id, message_id = excluded.message_id,
+			roles_hash = excluded.roles_hash, title = excluded.title, description = excluded.description, color = excluded.color,
+			style = excluded.style, max_roles = excluded.max_roles
+		RETURNING id`,
+		panel.Name, panel.ChannelID, panel.MessageID, panel.RolesHash, panel.Title, panel.Description, panel.Color, panel.Style, panel.MaxRoles,
+	).Scan(&id)
+	return id, err
+}
+
+// SetRolePanelRoles replaces all roles of a panel, keeping their order
+func SetRolePanelRoles(panelID int64, roles []models.ReactionRole) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM role_panel_roles WHERE panel_id = ?", panelID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO role_panel_roles (panel_id, role_id, name, emoji, group_name, required_roles, blocked_roles, duration, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, r := range roles {
+		if _, err := stmt.Exec(panelID, r.ID, r.Name, r.Emoji.MessageFormat(), r.Group, strings.Join(r.RequiredRoles, ","), strings.Join(r.BlockedRoles, ","), int64(r.Duration/time.Second), i); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetRolePanelGroup creates or updates a group of a panel
+func SetRolePanelGroup(panelID int64, group models.RoleGroup) error {
+	_, err := DB.Exec(
+		"INSERT INTO role_panel_groups (panel_id, name, max_roles) VALUES (?, ?, ?) ON CONFLICT(panel_id, name) DO UPDATE SET max_roles = excluded.max_roles",
+		panelID, group.Name, group.Max,
+	)
+	return err
+}
+
+// DeleteRolePanelGroup removes a group from a panel, leaving its roles ungrouped
+func DeleteRolePanelGroup(panelID int64, name string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE role_panel_roles SET group_name = '' WHERE panel_id = ? AND group_name = ?", panelID, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM role_panel_groups WHERE panel_id = ? AND name = ?", panelID, name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRolePanel removes a panel and its roles
+func DeleteRolePanel(panelID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM role_panel_roles WHERE panel_id = ?", panelID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM role_panel_groups WHERE panel_id = ?", panelID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM role_panels WHERE id = ?", panelID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddTempRole stores when a member's role expires, replacing an earlier expiry
+func AddTempRole(role models.TempRole) error {
+	_, err := DB.Exec(
+		"INSERT OR REPLACE INTO temp_roles (guild_id, user_id, role_id, expires_at) VALUES (?, ?, ?, ?)",
+		role.GuildID, role.UserID, role.RoleID, role.ExpiresAt.Unix(),
+	)
+	return err
+}
+
+// DeleteTempRole forgets the expiry of a member's role
+func DeleteTempRole(userID, roleID string) error {
+	_, err := DB.Exec("DELETE FROM temp_roles WHERE user_id = ? AND role_id = ?", userID, roleID)
+	return err
+}
+
+// GetExpiredTempRoles returns every role that expired before the given time
+func GetExpiredTempRoles(before time.Time) ([]models.TempRole, error) {
+	rows, err := DB.Query("SELECT guild_id, user_id, role_id, expires_at FROM temp_roles WHERE expires_at <= ?", before.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.TempRole
+	for rows.Next() {
+		var r models.TempRole
+		var expiresAt int64
+		if err := rows.Scan(&r.GuildID, &r.UserID, &r.RoleID, &expiresAt); err != nil {
+			return nil, err
+		}
+		r.ExpiresAt = time.Unix(expiresAt, 0)
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// AddStuckReaction remembers a panel reaction that was handled but couldn't be removed
+func AddStuckReaction(messageID, emoji, userID string) error {
+	_, err := DB.Exec(
+		"INSERT OR IGNORE INTO stuck_reactions (message_id, emoji, user_id) VALUES (?, ?, ?)",
+		messageID, emoji, userID,
+	)
+	return err
+}
+
+// DeleteStuckReaction forgets a stuck reaction once it is gone
+func DeleteStuckReaction(messageID, emoji, userID string) error {
+	_, err := DB.Exec(
+		"DELETE FROM stuck_reactions WHERE message_id = ? AND emoji = ? AND user_id = ?",
+		messageID, emoji, userID,
+	)
+	return err
+}
+
+// GetStuckReactions returns the users whose reaction with the emoji on a message is stuck
+func GetStuckReactions(messageID, emoji string) (map[string]bool, error) {
+	rows, err := DB.Query("SELECT user_id FROM stuck_reactions WHERE message_id = ? AND emoji = ?", messageID, emoji)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		users[userID] = true
+	}
+	return users, rows.Err()
+}
+
+// AddRoleEvent records a panel role change
+func AddRoleEvent(event models.RoleEvent) error {
+	_, err := DB.Exec(
+		"INSERT INTO role_events (user_id, role_id, action, created_at) VALUES (?, ?, ?, ?)",
+		event.UserID, event.RoleID, event.Action, event.CreatedAt.Unix(),
+	)
+	return err
+}
+
+// CountRoleEvents counts role events since the given time per role, per period and per action.
+// Periods are numbered from 0, starting at since.
+func CountRoleEvents(since time.Time, period time.Duration) (map[string]map[int]map[string]int, error) {
+	seconds := int64(period / time.Second)
+	rows, err := DB.Query(
+		"SELECT role_id, (created_at - ?) / ? AS period, action, COUNT(*) FROM role_events WHERE created_at >= ? GROUP BY role_id, period, action",
+		since.Unix(), seconds, since.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]map[int]map[string]int)
+	for rows.Next() {
+		var roleID, action string
+		var bucket, count int
+		if err := rows.Scan(&roleID, &bucket, &action, &count); err != nil {
+			return nil, err
+		}
+		if counts[roleID] == nil {
+			counts[roleID] = make(map[int]map[string]int)
+		}
+		if counts[roleID][bucket] == nil {
+			counts[roleID][bucket] = make(map[string]int)
+		}
+		counts[roleID][bucket][action] = count
+	}
+	return counts, rows.Err()
+}
+
+// DeleteUserData removes a user's reminders and reaction role history. Moderation records
+// like anonymous channel bans and message mappings are kept, and so are timed roles, which
+// the expiry job still needs to take away again.
+func DeleteUserData(userID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"reminders", "role_events", "stuck_reactions"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetOnboardingEntry queues a new member for their default roles, replacing an earlier entry
+func SetOnboardingEntry(entry models.OnboardingEntry) error {
+	_, err := DB.Exec(
+		"INSERT OR REPLACE INTO onboarding_queue (user_id, guild_id, assign_at, awaiting_screening, departed) VALUES (?, ?, ?, ?, 0)",
+		entry.UserID, entry.GuildID, entry.AssignAt.Unix(), entry.AwaitingScreening,
+	)
+	return err
+}
+
+// GetOnboardingEntry returns the queued entry of a member, or sql.ErrNoRows if there is none
+func GetOnboardingEntry(userID, guildID string) (models.OnboardingEntry, error) {
+	var entry models.OnboardingEntry
+	var assignAt int64
+	err := DB.QueryRow(
+		"SELECT user_id, guild_id, assign_at, awaiting_screening FROM onboarding_queue WHERE user_id = ? AND guild_id = ?",
+		userID, guildID,
+	).Scan(&entry.UserID, &entry.GuildID, &assignAt, &entry.AwaitingScreening)
+	entry.AssignAt = time.Unix(assignAt, 0)
+	return entry, err
+}
+
+// SetOnboardingDeparted marks a queued member as gone. The entry is kept so they
+// still get their default roles if they come back.
+func SetOnboardingDeparted(userID, guildID string) error {
+	_, err := DB.Exec("UPDATE onboarding_queue SET departed = 1 WHERE user_id = ? AND guild_id = ?", userID, guildID)
+	return err
+}
+
+// DeleteOnboardingEntry removes a member from the onboarding queue
+func DeleteOnboardingEntry(userID, guildID string) error {
+	_, err := DB.Exec("DELETE FROM onboarding_queue WHERE user_id = ? AND guild_id = ?", userID, guildID)
+	return err
+}
+
+// DeleteDepartedOnboarding removes the queue entry of a member who is gone
+func DeleteDepartedOnboarding(userID, guildID string) error {
+	_, err := DB.Exec("DELETE FROM onboarding_queue WHERE user_id = ? AND guild_id = ? AND departed = 1", userID, guildID)
+	return err
+}
+
+// GetDueOnboardingEntries returns every queued member whose default roles are due and who passed screening
+func GetDueOnboardingEntries(now time.Time) ([]models.OnboardingEntry, error) {
+	rows, err := DB.Query(
+		"SELECT user_id, guild_id, assign_at FROM onboarding_queue WHERE awaiting_screening = 0 AND departed = 0 AND assign_at <= ?",
+		now.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.OnboardingEntry
+	for rows.Next() {
+		var entry models.OnboardingEntry
+		var assignAt int64
+		if err := rows.Scan(&entry.UserID, &entry.GuildID, &assignAt); err != nil {
+			return nil, err
+		}
+		entry.AssignAt = time.Unix(assignAt, 0)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// AddVerification marks a new member as not verified yet
+func AddVerification(v models.Verification) error {
+	_, err := DB.Exec(
+		"INSERT OR REPLACE INTO verifications (user_id, guild_id, joined_at, attempts, departed) VALUES (?, ?, ?, ?, 0)",
+		v.UserID, v.GuildID, v.JoinedAt.Unix(), v.Attempts,
+	)
+	return err
+}
+
+// GetVerification returns a member's pending verification, or sql.ErrNoRows if they don't need to verify
+func GetVerification(userID, guildID string) (models.Verification, error) {
+	var v models.Verification
+	var joinedAt int64
+	err := DB.QueryRow(
+		"SELECT user_id, guild_id, joined_at, attempts FROM verifications WHERE user_id = ? AND guild_id = ?",
+		userID, guildID,
+	).Scan(&v.UserID, &v.GuildID, &joinedAt, &v.Attempts)
+	v.JoinedAt = time.Unix(joinedAt, 0)
+	return v, err
+}
+
+// AddVerificationAttempt counts a wrong answer and returns the new total
+func AddVerificationAttempt(userID, guildID string) (int, error) {
+	var attempts int
+	err := DB.QueryRow(
+		"UPDATE verifications SET attempts = attempts + 1 WHERE user_id = ? AND guild_id = ? RETURNING attempts",
+		userID, guildID,
+	).Scan(&attempts)
+	return attempts, err
+}
+
+// SetVerificationDeparted marks an unverified member as gone. The entry is kept so
+// they still have to verify if they come back.
+func SetVerificationDeparted(userID, guildID string) error {
+	_, err := DB.Exec("UPDATE verifications SET departed = 1 WHERE user_id = ? AND guild_id = ?", userID, guildID)
+	return err
+}
+
+// DeleteVerification removes a member's pending verification
+func DeleteVerification(userID, guildID string) error {
+	_, err := DB.Exec("DELETE FROM verifications WHERE user_id = ? AND guild_id = ?", userID, guildID)
+	return err
+}
+
+// DeleteDepartedVerification removes the pending verification of a member who is gone
+func DeleteDepartedVerification(userID, guildID string) error {
+	_, err := DB.Exec("DELETE FROM verifications WHERE user_id = ? AND guild_id = ? AND departed = 1", userID, guildID)
+	return err
+}
+
+// GetVerificationsJoinedBefore returns every pending verification of current members who joined before the given time
+func GetVerificationsJoinedBefore(before time.Time) ([]models.Verification, error) {
+	rows, err := DB.Query(
+		"SELECT user_id, guild_id, joined_at, attempts FROM verifications WHERE departed = 0 AND joined_at < ?",
+		before.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var verifications []models.Verification
+	for rows.Next() {
+		var v models.Verification
+		var joinedAt int64
+		if err := rows.Scan(&v.UserID, &v.GuildID, &joinedAt, &v.Attempts); err != nil {
+			return nil, err
+		}
+		v.JoinedAt = time.Unix(joinedAt, 0)
+		verifications = append(verifications, v)
+	}
+	return verifications, rows.Err()
+}
@@ -0,0 +1,208 @@
+package discord
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"teamacedia/discord-bot/internal/anonimize"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const anonEditModalPrefix = "anon_edit:"
+
+// parseMessageID accepts either a raw message ID or a message link and returns the message ID
+func parseMessageID(input string) string {
+	input = strings.TrimSuffix(strings.TrimSpace(input), "/")
+	parts := strings.Split(input, "/")
+	return parts[len(parts)-1]
+}
+
+func handleAnonCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if !isAdmin(i.Member) {
+		replyEphemeral(s, i, "You do not have permission to use this command.")
+		return
+	}
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "reveal":
+		handleAnonReveal(s, i, sub)
+	case "ban":
+		handleAnonBan(s, i, sub)
+	case "unban":
+		handleAnonUnban(s, i, sub)
+	}
+}
+
+func handleAnonReveal(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	messageID := parseMessageID(sub.GetOption("message").StringValue())
+
+	msg, authorID, err := anonimize.RevealAuthor(messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		replyEphemeral(s, i, "No anonymous message with that ID is on record.")
+		return
+	}
+	if err != nil {
+		replyEphemeral(s, i, "Failed to reveal author: "+err.Error())
+		return
+	}
+
+	messageLink := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", i.GuildID, msg.ChannelID, msg.MessageID)
+
+	// Every reveal is audited in the log channel
+	audit := &discordgo.MessageEmbed{
+		Title: "Anonymous Author Revealed",
+		Color: 0xff8800,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Moderator", Value: fmt.Sprintf("<@%s>", i.Member.User.ID), Inline: true},
+			{Name: "Author", Value: fmt.Sprintf("<@%s>", authorID), Inline: true},
+			{Name: "Message", Value: fmt.Sprintf("[Jump to message](%s)", messageLink), Inline: false},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if _, err := s.ChannelMessageSendEmbed(config.Config.LogChannelID, audit); err != nil {
+		log.Printf("Failed to post anon reveal audit entry: %v", err)
+		replyEphemeral(s, i, "Refusing to reveal the author because the audit entry could not be posted.")
+		return
+	}
+
+	replyEphemeral(s, i, fmt.Sprintf("[This message](%s) was sent by <@%s>, posted <t:%d:R>.", messageLink, authorID, msg.CreatedAt.Unix()))
+}
+
+func handleAnonBan(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	user := sub.GetOption("user").UserValue(nil)
+
+	ban := models.AnonBan{
+		UserID:   user.ID,
+		BannedBy: i.Member.User.ID,
+	}
+	if opt := sub.GetOption("reason"); opt != nil {
+		ban.Reason = opt.StringValue()
+	}
+
+	until := "permanently"
+	if opt := sub.GetOption("duration"); opt != nil {
+		duration, err := config.ParseDuration(opt.StringValue())
+		if err != nil || duration <= 0 {
+			replyEphemeral(s, i, "Invalid duration, use a value like 30m, 12h or 7d.")
+			return
+		}
+		ban.ExpiresAt = time.Now().Add(duration)
+		until = fmt.Sprintf("until <t:%d:f>", ban.ExpiresAt.Unix())
+	}
+
+	if err := db.AddAnonBan(ban); err != nil {
+		replyEphemeral(s, i, "Failed to ban user: "+err.Error())
+		return
+	}
+
+	reason := ban.Reason
+	if reason == "" {
+		reason = "No reason given"
+	}
+	audit := &discordgo.MessageEmbed{
+		Title: "Anonymous Channel Ban",
+		Color: 0xff0000,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Moderator", Value: fmt.Sprintf("<@%s>", ban.BannedBy), Inline: true},
+			{Name: "User", Value: fmt.Sprintf("<@%s>", ban.UserID), Inline: true},
+			{Name: "Duration", Value: until, Inline: true},
+			{Name: "Reason", Value: reason, Inline: false},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if _, err := s.ChannelMessageSendEmbed(config.Config.LogChannelID, audit); err != nil {
+		log.Printf("Failed to post anon ban audit entry: %v", err)
+	}
+
+	replyEphemeral(s, i, fmt.Sprintf("<@%s> is banned from anonymous channels %s.", ban.UserID, until))
+}
+
+func handleAnonUnban(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	user := sub.GetOption("user").UserValue(nil)
+
+	if err := db.DeleteAnonBan(user.ID); err != nil {
+		replyEphemeral(s, i, "Failed to unban user: "+err.Error())
+		return
+	}
+
+	audit := &discordgo.MessageEmbed{
+		Title: "Anonymous Channel Unban",
+		Color: 0x00ff00,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Moderator", Value: fmt.Sprintf("<@%s>", i.Member.User.ID), Inline: true},
+			{Name: "User", Value: fmt.Sprintf("<@%s>", user.ID), Inline: true},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if _, err := s.ChannelMessageSendEmbed(config.Config.LogChannelID, audit); err != nil {
+		log.Printf("Failed to post anon unban audit entry: %v", err)
+	}
+
+	replyEphemeral(s, i, fmt.Sprintf("<@%s> can use anonymous channels again.", user.ID))
+}
+
+func handleAnonEditCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	// Check before opening the modal so non-authors don't type an edit for nothing
+	if err := anonimize.VerifyAuthor(i.Member.User.ID, data.TargetID); err != nil {
+		replyEphemeral(s, i, "Failed to edit message: "+err.Error())
+		return
+	}
+
+	// Only the author's own text is editable, the reply quote stays as it is
+	quote, content := "", ""
+	if msg, ok := data.Resolved.Messages[data.TargetID]; ok {
+		quote, content = anonimize.SplitQuote(msg.Content)
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: anonEditModalPrefix + data.TargetID,
+			Title:    "Edit anonymous message",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  "content",
+							Label:     "Message",
+							Style:     discordgo.TextInputParagraph,
+							Value:     content,
+							Required:  true,
+							MaxLength: 2000 - len([]rune(quote)),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding with anon edit modal: %v", err)
+	}
+}
+
+func handleAnonEditSubmit(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ModalSubmitInteractionData) {
+	messageID := strings.TrimPrefix(data.CustomID, anonEditModalPrefix)
+	content := data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
+
+	if err := anonimize.EditMessage(s, i.Member.User.ID, messageID, content); err != nil {
+		replyEphemeral(s, i, "Failed to edit message: "+err.Error())
+		return
+	}
+	replyEphemeral(s, i, "Your anonymous message has been edited.")
+}
+
+func handleAnonDeleteCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if err := anonimize.DeleteMessage(s, i.Member.User.ID, data.TargetID); err != nil {
+		replyEphemeral(s, i, "Failed to delete message: "+err.Error())
+		return
+	}
+	replyEphemeral(s, i, "Your anonymous message has been deleted.")
+}
@@ -4,22 +4,33 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
+	"teamacedia/discord-bot/internal/anonimize"
 	"teamacedia/discord-bot/internal/config"
 	"teamacedia/discord-bot/internal/db"
 	"teamacedia/discord-bot/internal/logging"
 	"teamacedia/discord-bot/internal/models"
+	"teamacedia/discord-bot/internal/onboarding"
 	"teamacedia/discord-bot/internal/reaction_roles"
 	"teamacedia/discord-bot/internal/sticky_roles"
+	"teamacedia/discord-bot/internal/verification"
+	"teamacedia/discord-bot/internal/welcome"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 var (
-	session  *discordgo.Session
-	cmdIDs   []*discordgo.ApplicationCommand
+	session       *discordgo.Session
+	reactionRoles *reaction_roles.State
+	cmdIDs        []*discordgo.ApplicationCommand
+
+	// Lowest values of integer options
+	zero   = 0.0
+	oneDay = 1.0
+
 	commands = []*discordgo.ApplicationCommand{
 		{
 			Name:        "help",
@@ -51,6 +62,383 @@ var (
 				},
 			},
 		},
+		{
+			Name:        "anon",
+			Description: "Moderation tools for anonymous channels",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reveal",
+					Description: "Reveal the author of an anonymous message (admins only, audited)",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "message",
+							Description: "Message ID or link of the anonymous message",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "ban",
+					Description: "Block a user from anonymous channels (admins only)",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Name:        "user",
+							Description: "User to ban",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "duration",
+							Description: "How long the ban lasts, e.g. 30m, 12h, 7d. Permanent if omitted",
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "reason",
+							Description: "Reason for the ban",
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "unban",
+					Description: "Allow a banned user to use anonymous channels again (admins only)",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Name:        "user",
+							Description: "User to unban",
+							Required:    true,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "rolepanel",
+			Description: "Manage reaction role panels (admins only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "create",
+					Description: "Post a new reaction role panel",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "name",
+							Description: "Unique name used to refer to the panel",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionChannel,
+							Name:        "channel",
+							Description: "Channel to post the panel in",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "title",
+							Description: "Panel title",
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "description",
+							Description: "Text shown above the roles",
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "color",
+							Description: "Embed color as hex, e.g. #00FFFF",
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "style",
+							Description: "How members pick roles (default reactions)",
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "Reactions", Value: models.PanelStyleReactions},
+								{Name: "Buttons", Value: models.PanelStyleButtons},
+								{Name: "Select menu", Value: models.PanelStyleSelect},
+							},
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "add",
+					Description: "Add a role to a panel, or change its emoji",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "panel",
+							Description:  "Name of the panel",
+							Required:     true,
+							Autocomplete: true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "Role to add",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "emoji",
+							Description: "Emoji for the role, e.g. 🐧 or <:name:id> for custom emojis",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "group",
+							Description: "Group the role belongs to, created with /rolepanel group",
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "duration",
+							Description: "Remove the role again this long after it was picked, e.g. 24h or 7d",
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "remove",
+					Description: "Remove a role from a panel",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "panel",
+							Description:  "Name of the panel",
+							Required:     true,
+							Autocomplete: true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "Role to remove",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "group",
+					Description: "Create or change a group that limits how many of its roles a member can hold",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "panel",
+							Description:  "Name of the panel",
+							Required:     true,
+							Autocomplete: true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "name",
+							Description: "Name of the group",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "max",
+							Description: "Most roles a member can hold from the group, 1 to pick one, 0 to remove the group",
+							Required:    true,
+							MinValue:    &zero,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "limit",
+					Description: "Limit how many roles a member can hold from a panel",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "panel",
+							Description:  "Name of the panel",
+							Required:     true,
+							Autocomplete: true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "max",
+							Description: "Most roles a member can hold, 0 for no limit",
+							Required:    true,
+							MinValue:    &zero,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "restrict",
+					Description: "Require or block another role for picking a panel role, run again to undo",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "panel",
+							Description:  "Name of the panel",
+							Required:     true,
+							Autocomplete: true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "role",
+							Description: "Panel role to restrict",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "type",
+							Description: "Whether members need the other role or must not have it",
+							Required:    true,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "Requires (any of)", Value: reaction_roles.RestrictRequire},
+								{Name: "Blocked by", Value: reaction_roles.RestrictBlock},
+							},
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionRole,
+							Name:        "other",
+							Description: "Role that is required or blocks picking it",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "delete",
+					Description: "Delete a panel and its message",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "panel",
+							Description:  "Name of the panel",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "List all reaction role panels",
+				},
+			},
+		},
+		{
+			Name:        "sticky",
+			Description: "Inspect and manage stored sticky roles (admins only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "view",
+					Description: "Show the roles, nickname and timeout stored for a member",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Name:        "user",
+							Description: "Member or former member",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "restore",
+					Description: "Give a member their stored roles, nickname and timeout back",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Name:        "user",
+							Description: "Member or former member",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "clear",
+					Description: "Delete the roles, nickname and timeout stored for a member",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Name:        "user",
+							Description: "Member or former member",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "forget",
+					Description: "Delete everything stored for a member so they count as new when they join",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Name:        "user",
+							Description: "Member or former member",
+							Required:    true,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "whois",
+			Description: "Show a user's join history (admins only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "user",
+					Description: "Member or former member",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "privacy",
+			Description: "Manage the data the bot stores about you",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "forget-me",
+					Description: "Delete all data the bot stores about you",
+				},
+			},
+		},
+		{
+			Name:        "rolestats",
+			Description: "Show how reaction roles are used (admins only)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "panel",
+					Description:  "Only show roles of this panel",
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "days",
+					Description: "How many days of changes to count (default 30)",
+					MinValue:    &oneDay,
+					MaxValue:    365,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "export",
+					Description: "Attach the statistics as a CSV file",
+				},
+			},
+		},
+		{
+			Name: "Edit my anon message",
+			Type: discordgo.MessageApplicationCommand,
+		},
+		{
+			Name: "Delete my anon message",
+			Type: discordgo.MessageApplicationCommand,
+		},
 	}
 )
 
@@ -61,11 +449,26 @@ func containsIgnoreCase(haystack, needle string) bool {
 func handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	data := i.ApplicationCommandData()
 
-	// Only autocomplete the removereminder command
-	if data.Name != "removereminder" {
-		return
+	switch data.Name {
+	case "removereminder":
+		autocompleteReminders(s, i, data)
+	case "rolepanel":
+		autocompleteRolePanels(s, i, data.Options[0].Options)
+	case "rolestats":
+		autocompleteRolePanels(s, i, data.Options)
 	}
+}
+
+func respondChoices(s *discordgo.Session, i *discordgo.InteractionCreate, choices []*discordgo.ApplicationCommandOptionChoice) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+}
 
+func autocompleteReminders(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
 	// User input so far
 	input := data.Options[0].StringValue()
 
@@ -93,12 +496,7 @@ func handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		}
 	}
 
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseType(8),
-		Data: &discordgo.InteractionResponseData{
-			Choices: choices,
-		},
-	})
+	respondChoices(s, i, choices)
 }
 
 func interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -107,6 +505,16 @@ func interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 
+	if i.Type == discordgo.InteractionModalSubmit {
+		handleModalSubmit(s, i)
+		return
+	}
+
+	if i.Type == discordgo.InteractionMessageComponent {
+		handleComponent(s, i)
+		return
+	}
+
 	if i.Type != discordgo.InteractionApplicationCommand {
 		return
 	}
@@ -116,7 +524,17 @@ func interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	var commands_description string = "Available commands:\n\n" +
 		"`/help` - Get a list of commands that work with this bot\n" +
 		"`/remindme [message]` - Set a reminder for yourself that sends daily until removed.\n" +
-		"`/removereminder [message]` - Remove a daily reminder set with `/remindme`\n"
+		"`/removereminder [message]` - Remove a daily reminder set with `/remindme`\n" +
+		"`/anon reveal [message]` - Reveal the author of an anonymous message (admins only)\n" +
+		"`/anon ban [user] [duration] [reason]` - Block a user from anonymous channels (admins only)\n" +
+		"`/anon unban [user]` - Lift a ban from anonymous channels (admins only)\n" +
+		"`/rolepanel create|add|remove|group|limit|restrict|delete|list` - Manage reaction role panels (admins only)\n" +
+		"`/rolestats [panel] [days] [export]` - Show how reaction roles are used (admins only)\n" +
+		"`/sticky view|restore|clear|forget [user]` - Manage a member's stored sticky roles (admins only)\n" +
+		"`/whois [user]` - Show a user's join history (admins only)\n" +
+		"`/privacy forget-me` - Delete all data the bot stores about you\n" +
+		"`Apps > Edit my anon message` - Edit one of your anonymous messages\n" +
+		"`Apps > Delete my anon message` - Delete one of your anonymous messages\n"
 
 	switch data.Name {
 	case "help":
@@ -158,6 +576,46 @@ func interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			}
 			replyEmbed(s, i, embed)
 		}
+	case "anon":
+		handleAnonCommand(s, i, data)
+	case "rolepanel":
+		handleRolePanelCommand(s, i, data)
+	case "rolestats":
+		handleRoleStatsCommand(s, i, data)
+	case "sticky":
+		handleStickyCommand(s, i, data)
+	case "whois":
+		handleWhoisCommand(s, i, data)
+	case "privacy":
+		handlePrivacyCommand(s, i, data)
+	case "Edit my anon message":
+		handleAnonEditCommand(s, i, data)
+	case "Delete my anon message":
+		handleAnonDeleteCommand(s, i, data)
+	}
+}
+
+func handleModalSubmit(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ModalSubmitData()
+
+	switch {
+	case strings.HasPrefix(data.CustomID, anonEditModalPrefix):
+		handleAnonEditSubmit(s, i, data)
+	case data.CustomID == verification.SubmitID:
+		handleVerifySubmit(s, i, data)
+	}
+}
+
+func handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
+
+	switch {
+	case reaction_roles.IsPanelComponent(data.CustomID):
+		handleRolePanelComponent(s, i)
+	case strings.HasPrefix(data.CustomID, privacyPrefix):
+		handlePrivacyComponent(s, i)
+	case strings.HasPrefix(data.CustomID, verification.Prefix):
+		handleVerifyComponent(s, i)
 	}
 }
 
@@ -185,6 +643,27 @@ func replyEmbed(s *discordgo.Session, i *discordgo.InteractionCreate, embed *dis
 	}
 }
 
+func replyEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, msg string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: msg,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// isAdmin checks if the member invoking an interaction holds the configured admin role
+func isAdmin(member *discordgo.Member) bool {
+	if member == nil || config.Config.AdminRoleID == "" {
+		return false
+	}
+	return slices.Contains(member.Roles, config.Config.AdminRoleID)
+}
+
 func DmUser(userID string, content string) error {
 	// Create or fetch DM channel
 	channel, err := session.UserChannelCreate(userID)
@@ -246,20 +725,36 @@ func Start(botToken string, appID string, guildID string) {
 	log.Println("Commands registered.")
 
 	// Set up handlers
-	state, err := reaction_roles.InitReactionRoles(session, config.Config.ReactionRoles)
+	anonimize.Init(session)
+
+	reactionRoles, err = reaction_roles.InitReactionRoles(session, config.Config.ReactionRoles)
 	if err != nil {
 		log.Fatal(err)
 	}
+	go reaction_roles.StartExpiry(session)
+	go reactionRoles.StartReconciler(session)
+	go onboarding.StartScheduler(session)
+	if verification.Enabled() {
+		if err := verification.EnsurePanel(session); err != nil {
+			log.Printf("Failed to post verification panel: %v", err)
+		}
+		go verification.StartDeadline(session)
+	}
 
 	// Register handlers
 	session.AddHandler(logging.OnMessageCreate)
 	session.AddHandler(logging.OnMessageUpdate)
 	session.AddHandler(logging.OnMessageDelete)
 	session.AddHandler(sticky_roles.OnMemberJoin)
+	session.AddHandler(sticky_roles.OnMemberLeave)
+	session.AddHandler(verification.OnMemberLeave)
+	session.AddHandler(welcome.OnMemberJoin)
+	session.AddHandler(welcome.OnMemberLeave)
 	session.AddHandler(sticky_roles.OnMemberUpdate)
+	session.AddHandler(onboarding.OnMemberUpdate)
 	session.AddHandler(sticky_roles.OnRoleDelete)
 	session.AddHandler(func(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
-		reaction_roles.HandleReactionAdd(s, r, state)
+		reaction_roles.HandleReactionAdd(s, r, reactionRoles)
 	})
 
 	session.AddHandler(interactionHandler)
@@ -270,6 +765,9 @@ func Start(botToken string, appID string, guildID string) {
 		log.Printf("Failed to sync guild roles: %v", err)
 	}
 
+	// Runs after the sync so members who left while offline count as departed
+	go sticky_roles.StartRetention(guildID)
+
 	// Wait here until Ctrl+C or kill signal
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
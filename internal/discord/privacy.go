@@ -0,0 +1,91 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/logging"
+	"teamacedia/discord-bot/internal/sticky_roles"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	privacyPrefix        = "privacy:"
+	privacyForgetConfirm = privacyPrefix + "forget-me"
+	privacyForgetCancel  = privacyPrefix + "cancel"
+)
+
+func handlePrivacyCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	switch data.Options[0].Name {
+	case "forget-me":
+		handlePrivacyForgetMe(s, i)
+	}
+}
+
+// handlePrivacyForgetMe asks the user to confirm before anything is deleted
+func handlePrivacyForgetMe(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "This deletes your stored roles, nickname, join history, reminders, reaction role history and cached messages. " +
+				"It can't be undone, and if you left and rejoin you will be treated as a new member.\n" +
+				"Moderation records, such as anonymous channel bans and punishment roles, are kept, and your data can't be deleted while you are timed out. " +
+				"Entries already posted to the server's log channels are kept as well.",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.Button{Label: "Delete my data", Style: discordgo.DangerButton, CustomID: privacyForgetConfirm},
+					discordgo.Button{Label: "Cancel", Style: discordgo.SecondaryButton, CustomID: privacyForgetCancel},
+				}},
+			},
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+func handlePrivacyComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	msg := "Nothing was deleted."
+	if i.MessageComponentData().CustomID == privacyForgetConfirm {
+		msg = forgetUser(i.GuildID, i.Member.User.ID)
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    msg,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// forgetUser deletes everything stored about a user and describes the outcome
+func forgetUser(guildID, userID string) string {
+	kept, err := sticky_roles.DeleteUserData(userID, guildID)
+	if errors.Is(err, sticky_roles.ErrTimedOut) {
+		return "Your data can't be deleted while you are timed out. Try again once the timeout ends."
+	}
+	if err != nil {
+		log.Printf("Failed to delete sticky data of %s: %v", userID, err)
+		return "Failed to delete your data, please try again later or contact a moderator."
+	}
+	if err := db.DeleteUserData(userID); err != nil {
+		log.Printf("Failed to delete data of %s: %v", userID, err)
+		return "Failed to delete your data, please try again later or contact a moderator."
+	}
+	cached := logging.ForgetUser(userID)
+
+	log.Printf("Deleted stored data of %s on request", userID)
+	msg := fmt.Sprintf("Your data has been deleted, including %d cached messages. "+
+		"While you stay in the server, your current roles are stored again the next time they change.", cached)
+	if kept > 0 {
+		msg += fmt.Sprintf(" %d moderation roles were kept.", kept)
+	}
+	return msg
+}
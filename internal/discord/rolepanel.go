@@ -0,0 +1,294 @@
+package discord
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/models"
+	"teamacedia/discord-bot/internal/reaction_roles"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func handleRolePanelCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if !isAdmin(i.Member) {
+		replyEphemeral(s, i, "You do not have permission to use this command.")
+		return
+	}
+
+	sub := data.Options[0]
+	switch sub.Name {
+	case "create":
+		handleRolePanelCreate(s, i, sub)
+	case "add":
+		handleRolePanelAdd(s, i, sub)
+	case "remove":
+		handleRolePanelRemove(s, i, sub)
+	case "group":
+		handleRolePanelGroup(s, i, sub)
+	case "limit":
+		handleRolePanelLimit(s, i, sub)
+	case "restrict":
+		handleRolePanelRestrict(s, i, sub)
+	case "delete":
+		handleRolePanelDelete(s, i, sub)
+	case "list":
+		handleRolePanelList(s, i)
+	}
+}
+
+// parseColor parses a hex color like #00FFFF or 00ffff
+func parseColor(input string) (int, error) {
+	color, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(input), "#"), 16, 32)
+	if err != nil || color > 0xFFFFFF {
+		return 0, fmt.Errorf("invalid color %q, use a hex value like #00FFFF", input)
+	}
+	return int(color), nil
+}
+
+func handleRolePanelCreate(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	panel := models.RolePanel{
+		Name:      sub.GetOption("name").StringValue(),
+		ChannelID: sub.GetOption("channel").ChannelValue(nil).ID,
+		Title:     "Reaction Roles",
+		Color:     0x00FFFF, // Cyan
+		Style:     models.PanelStyleReactions,
+	}
+	if opt := sub.GetOption("style"); opt != nil {
+		panel.Style = opt.StringValue()
+	}
+	if opt := sub.GetOption("title"); opt != nil {
+		panel.Title = opt.StringValue()
+	}
+	if opt := sub.GetOption("description"); opt != nil {
+		panel.Description = opt.StringValue()
+	}
+	if opt := sub.GetOption("color"); opt != nil {
+		color, err := parseColor(opt.StringValue())
+		if err != nil {
+			replyEphemeral(s, i, err.Error())
+			return
+		}
+		panel.Color = color
+	}
+
+	if err := reactionRoles.CreatePanel(s, panel); err != nil {
+		replyEphemeral(s, i, "Failed to create panel: "+err.Error())
+		return
+	}
+	replyEphemeral(s, i, fmt.Sprintf("Panel `%s` posted in <#%s>. Add roles with `/rolepanel add`.", panel.Name, panel.ChannelID))
+}
+
+func handleRolePanelAdd(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	name := sub.GetOption("panel").StringValue()
+	role := sub.GetOption("role").RoleValue(s, i.GuildID)
+
+	emoji, err := models.ParseEmoji(sub.GetOption("emoji").StringValue())
+	if err != nil {
+		replyEphemeral(s, i, err.Error())
+		return
+	}
+
+	rr := models.ReactionRole{
+		ID:    role.ID,
+		Name:  role.Name,
+		Emoji: emoji,
+	}
+	if opt := sub.GetOption("group"); opt != nil {
+		rr.Group = strings.TrimSpace(opt.StringValue())
+	}
+	if opt := sub.GetOption("duration"); opt != nil {
+		rr.Duration, err = config.ParseDuration(opt.StringValue())
+		if err != nil || rr.Duration < time.Minute {
+			replyEphemeral(s, i, "Invalid duration, use at least a minute, e.g. 30m, 24h or 7d.")
+			return
+		}
+	}
+
+	if err := reactionRoles.AddRole(s, name, rr); err != nil {
+		replyEphemeral(s, i, "Failed to add role: "+err.Error())
+		return
+	}
+	replyEphemeral(s, i, fmt.Sprintf("Added %s <@&%s> to panel `%s`.", rr.Emoji.MessageFormat(), rr.ID, name))
+}
+
+func handleRolePanelRemove(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	name := sub.GetOption("panel").StringValue()
+	role := sub.GetOption("role").RoleValue(nil, "")
+
+	if err := reactionRoles.RemoveRole(s, name, role.ID); err != nil {
+		replyEphemeral(s, i, "Failed to remove role: "+err.Error())
+		return
+	}
+	replyEphemeral(s, i, fmt.Sprintf("Removed <@&%s> from panel `%s`.", role.ID, name))
+}
+
+func handleRolePanelGroup(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	name := sub.GetOption("panel").StringValue()
+	group := models.RoleGroup{
+		Name: strings.TrimSpace(sub.GetOption("name").StringValue()),
+		Max:  int(sub.GetOption("max").IntValue()),
+	}
+
+	if err := reactionRoles.SetGroup(s, name, group); err != nil {
+		replyEphemeral(s, i, "Failed to update group: "+err.Error())
+		return
+	}
+
+	switch group.Max {
+	case 0:
+		replyEphemeral(s, i, fmt.Sprintf("Removed group `%s` from panel `%s`. Its roles are no longer limited.", group.Name, name))
+	case 1:
+		replyEphemeral(s, i, fmt.Sprintf("Members can now pick one role from group `%s` on panel `%s`. Put roles in it with `/rolepanel add`.", group.Name, name))
+	default:
+		replyEphemeral(s, i, fmt.Sprintf("Members can now pick up to %d roles from group `%s` on panel `%s`. Put roles in it with `/rolepanel add`.", group.Max, group.Name, name))
+	}
+}
+
+func handleRolePanelLimit(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	name := sub.GetOption("panel").StringValue()
+	limit := int(sub.GetOption("max").IntValue())
+
+	if err := reactionRoles.SetLimit(s, name, limit); err != nil {
+		replyEphemeral(s, i, "Failed to set limit: "+err.Error())
+		return
+	}
+
+	if limit == 0 {
+		replyEphemeral(s, i, fmt.Sprintf("Panel `%s` no longer limits how many roles members can pick.", name))
+		return
+	}
+	replyEphemeral(s, i, fmt.Sprintf("Members can now pick up to %d roles from panel `%s`.", limit, name))
+}
+
+func handleRolePanelRestrict(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	name := sub.GetOption("panel").StringValue()
+	roleID := sub.GetOption("role").RoleValue(nil, "").ID
+	kind := sub.GetOption("type").StringValue()
+	otherID := sub.GetOption("other").RoleValue(nil, "").ID
+
+	list, err := reactionRoles.ToggleRestriction(s, name, roleID, kind, otherID)
+	if err != nil {
+		replyEphemeral(s, i, "Failed to update restriction: "+err.Error())
+		return
+	}
+
+	mentions := make([]string, len(list))
+	for idx, id := range list {
+		mentions[idx] = fmt.Sprintf("<@&%s>", id)
+	}
+
+	switch {
+	case kind == reaction_roles.RestrictRequire && len(list) == 0:
+		replyEphemeral(s, i, fmt.Sprintf("Anyone can now pick <@&%s> from panel `%s`.", roleID, name))
+	case kind == reaction_roles.RestrictRequire:
+		replyEphemeral(s, i, fmt.Sprintf("Members now need one of %s to pick <@&%s>.", strings.Join(mentions, ", "), roleID))
+	case len(list) == 0:
+		replyEphemeral(s, i, fmt.Sprintf("No roles block picking <@&%s> anymore.", roleID))
+	default:
+		replyEphemeral(s, i, fmt.Sprintf("Members with %s can no longer pick <@&%s>.", strings.Join(mentions, ", "), roleID))
+	}
+}
+
+func handleRolePanelDelete(s *discordgo.Session, i *discordgo.InteractionCreate, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	name := sub.GetOption("panel").StringValue()
+
+	if err := reactionRoles.DeletePanel(s, name); err != nil {
+		replyEphemeral(s, i, "Failed to delete panel: "+err.Error())
+		return
+	}
+	replyEphemeral(s, i, fmt.Sprintf("Panel `%s` deleted.", name))
+}
+
+func handleRolePanelList(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	panels := reactionRoles.Panels()
+	if len(panels) == 0 {
+		replyEphemeral(s, i, "There are no reaction role panels yet. Create one with `/rolepanel create`.")
+		return
+	}
+
+	desc := ""
+	for _, p := range panels {
+		link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", i.GuildID, p.ChannelID, p.MessageID)
+		desc += fmt.Sprintf("`%s` - [%s](%s) in <#%s>, %d roles\n", p.Name, p.Title, link, p.ChannelID, len(p.Roles))
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:       "Reaction Role Panels",
+				Description: desc,
+				Color:       0x00FFFF, // Cyan
+			}},
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction with embed: %v", err)
+	}
+}
+
+// autocompleteRolePanels suggests panel names for the panel option among the given options
+func autocompleteRolePanels(s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	// User input so far
+	input := ""
+	for _, opt := range options {
+		if opt.Name == "panel" {
+			input = opt.StringValue()
+		}
+	}
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, p := range reactionRoles.Panels() {
+		if input == "" || containsIgnoreCase(p.Name, input) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  p.Name,
+				Value: p.Name,
+			})
+		}
+
+		// Discord allows max 25 choices
+		if len(choices) >= 25 {
+			break
+		}
+	}
+
+	respondChoices(s, i, choices)
+}
+
+// handleRolePanelComponent toggles roles from a panel button or select menu
+func handleRolePanelComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	// Role changes can take longer than Discord waits for a response
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		log.Printf("Error deferring interaction response: %v", err)
+		return
+	}
+
+	description, components, err := reactionRoles.HandleComponent(s, i)
+	if err != nil {
+		log.Printf("Failed to update roles from panel: %v", err)
+		description += "I could not update your roles: " + err.Error()
+	}
+
+	edit := &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{{
+			Title:       "Role Update",
+			Description: description,
+			Color:       0x00ffcc,
+		}},
+	}
+	if components != nil {
+		edit.Components = &components
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, edit); err != nil {
+		log.Printf("Error editing interaction response: %v", err)
+	}
+}
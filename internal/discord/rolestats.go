@@ -0,0 +1,139 @@
+package discord
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"teamacedia/discord-bot/internal/reaction_roles"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func handleRoleStatsCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if !isAdmin(i.Member) {
+		replyEphemeral(s, i, "You do not have permission to use this command.")
+		return
+	}
+
+	name := ""
+	days := 30
+	export := false
+	for _, opt := range data.Options {
+		switch opt.Name {
+		case "panel":
+			name = opt.StringValue()
+		case "days":
+			days = int(opt.IntValue())
+		case "export":
+			export = opt.BoolValue()
+		}
+	}
+
+	// Counting holders pages through every member, which can take longer than Discord waits for a reply
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error deferring interaction: %v", err)
+		return
+	}
+
+	// Trends are shown per day, or per week for longer periods, starting at midnight UTC
+	period, periodName := 24*time.Hour, "day"
+	if days > 31 {
+		period, periodName = 7*24*time.Hour, "week"
+	}
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	stats, err := reactionRoles.Stats(s, i.GuildID, name, since, period)
+	if err != nil {
+		msg := "Failed to collect role statistics: " + err.Error()
+		editResponse(s, i, &discordgo.WebhookEdit{Content: &msg})
+		return
+	}
+	if len(stats) == 0 {
+		msg := "There are no reaction roles to report on yet."
+		editResponse(s, i, &discordgo.WebhookEdit{Content: &msg})
+		return
+	}
+
+	desc := ""
+	lastPanel := ""
+	for _, rs := range stats {
+		if rs.Panel != lastPanel {
+			desc += fmt.Sprintf("\n**%s**\n", rs.Panel)
+			lastPanel = rs.Panel
+		}
+		net := rs.Added - rs.Removed - rs.Expired
+		desc += fmt.Sprintf("<@&%s> - %d holders, +%d / -%d", rs.RoleID, rs.Holders, rs.Added, rs.Removed+rs.Expired)
+		if rs.Expired > 0 {
+			desc += fmt.Sprintf(" (%d expired)", rs.Expired)
+		}
+		desc += fmt.Sprintf(", net %+d\n", net)
+
+		added := make([]int, len(rs.Trend))
+		removed := make([]int, len(rs.Trend))
+		for idx, t := range rs.Trend {
+			added[idx] = t.Added
+			removed[idx] = t.Removed + t.Expired
+		}
+		peak := max(slices.Max(added), slices.Max(removed))
+		desc += fmt.Sprintf("`%s` added `%s` removed\n", sparkline(added, peak), sparkline(removed, peak))
+	}
+
+	// Embed descriptions are capped at 4096 characters
+	if len(desc) > 4000 {
+		desc = desc[:strings.LastIndex(desc[:4000], "\n")] + "\n…use `export` for the full list"
+	}
+
+	edit := &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{{
+			Title:       "Reaction Role Statistics",
+			Description: desc,
+			Color:       0x00FFFF, // Cyan
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("Changes over the last %d days, one bar per %s", days, periodName),
+			},
+		}},
+	}
+
+	if export {
+		var buf bytes.Buffer
+		if err := reaction_roles.WriteStatsCSV(&buf, stats); err != nil {
+			log.Printf("Failed to write role statistics CSV: %v", err)
+		} else {
+			edit.Files = []*discordgo.File{{
+				Name:        fmt.Sprintf("rolestats-%s.csv", time.Now().Format("2006-01-02")),
+				ContentType: "text/csv",
+				Reader:      &buf,
+			}}
+		}
+	}
+
+	editResponse(s, i, edit)
+}
+
+// sparkline draws values as a row of bars scaled to peak, so trends fit on one line
+func sparkline(values []int, peak int) string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+	line := make([]rune, len(values))
+	for idx, v := range values {
+		line[idx] = bars[0]
+		if peak > 0 && v > 0 {
+			line[idx] = bars[(v*(len(bars)-1)+peak-1)/peak]
+		}
+	}
+	return string(line)
+}
+
+// editResponse replaces a deferred interaction response
+func editResponse(s *discordgo.Session, i *discordgo.InteractionCreate, edit *discordgo.WebhookEdit) {
+	if _, err := s.InteractionResponseEdit(i.Interaction, edit); err != nil {
+		log.Printf("Error editing interaction response: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package discord
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/sticky_roles"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func handleStickyCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if !isAdmin(i.Member) {
+		replyEphemeral(s, i, "You do not have permission to use this command.")
+		return
+	}
+
+	sub := data.Options[0]
+	user := sub.GetOption("user").UserValue(nil)
+
+	switch sub.Name {
+	case "view":
+		handleStickyView(s, i, user.ID)
+	case "restore":
+		handleStickyRestore(s, i, user.ID)
+	case "clear":
+		handleStickyClear(s, i, user.ID)
+	case "forget":
+		handleStickyForget(s, i, user.ID)
+	}
+}
+
+// roleMentions formats role IDs as mentions, or returns fallback if there are none
+func roleMentions(roleIDs []string, fallback string) string {
+	if len(roleIDs) == 0 {
+		return fallback
+	}
+	mentions := make([]string, len(roleIDs))
+	for idx, id := range roleIDs {
+		mentions[idx] = fmt.Sprintf("<@&%s>", id)
+	}
+	return strings.Join(mentions, ", ")
+}
+
+func handleStickyView(s *discordgo.Session, i *discordgo.InteractionCreate, userID string) {
+	record, err := sticky_roles.GetRecord(userID, i.GuildID)
+	if err != nil {
+		replyEphemeral(s, i, "Failed to load sticky data: "+err.Error())
+		return
+	}
+
+	joined := "No"
+	if record.Joined {
+		joined = "Yes"
+	}
+	nick := record.Nick
+	if nick == "" {
+		nick = "None"
+	}
+	timeout := "None"
+	if record.TimeoutUntil.After(time.Now()) {
+		timeout = fmt.Sprintf("Until <t:%d:f>", record.TimeoutUntil.Unix())
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{{
+				Title: "Sticky Data",
+				Color: 0x00FFFF, // Cyan
+				Fields: []*discordgo.MessageEmbedField{
+					{Name: "Member", Value: fmt.Sprintf("<@%s>", userID), Inline: true},
+					{Name: "Joined Before", Value: joined, Inline: true},
+					{Name: "Nickname", Value: nick, Inline: true},
+					{Name: "Timeout", Value: timeout, Inline: true},
+					{Name: "Roles", Value: roleMentions(record.Roles, "None"), Inline: false},
+				},
+			}},
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction with embed: %v", err)
+	}
+}
+
+func handleStickyRestore(s *discordgo.Session, i *discordgo.InteractionCreate, userID string) {
+	// Restoring takes several requests, which can take longer than Discord waits for a reply
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error deferring interaction: %v", err)
+		return
+	}
+
+	msg := ""
+	restored, failed, err := sticky_roles.Restore(s, i.GuildID, userID)
+	if err != nil {
+		msg = "Failed to restore sticky data: " + err.Error()
+	} else {
+		msg = fmt.Sprintf("Restored %s for <@%s>.", roleMentions(restored, "no roles"), userID)
+		if len(failed) > 0 {
+			msg += fmt.Sprintf(" Could not restore %s.", roleMentions(failed, ""))
+		}
+	}
+	editResponse(s, i, &discordgo.WebhookEdit{Content: &msg})
+}
+
+func handleStickyClear(s *discordgo.Session, i *discordgo.InteractionCreate, userID string) {
+	if err := sticky_roles.Clear(userID, i.GuildID); err != nil {
+		replyEphemeral(s, i, "Failed to clear sticky data: "+err.Error())
+		return
+	}
+
+	postStickyAudit(s, i, "Sticky Data Cleared", userID)
+	replyEphemeral(s, i, fmt.Sprintf("Cleared the stored roles, nickname and timeout of <@%s>. If they are still in the server, their next role change is stored again.", userID))
+}
+
+func handleStickyForget(s *discordgo.Session, i *discordgo.InteractionCreate, userID string) {
+	if err := sticky_roles.Forget(userID, i.GuildID); err != nil {
+		replyEphemeral(s, i, "Failed to forget member: "+err.Error())
+		return
+	}
+
+	postStickyAudit(s, i, "Sticky Data Forgotten", userID)
+	replyEphemeral(s, i, fmt.Sprintf("Forgot everything stored about <@%s>. They will be treated as a new member when they join.", userID))
+}
+
+// postStickyAudit records a moderator wiping sticky data in the log channel
+func postStickyAudit(s *discordgo.Session, i *discordgo.InteractionCreate, title, userID string) {
+	audit := &discordgo.MessageEmbed{
+		Title: title,
+		Color: 0xffa500,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Moderator", Value: fmt.Sprintf("<@%s>", i.Member.User.ID), Inline: true},
+			{Name: "User", Value: fmt.Sprintf("<@%s>", userID), Inline: true},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if _, err := s.ChannelMessageSendEmbed(config.Config.LogChannelID, audit); err != nil {
+		log.Printf("Failed to post sticky audit entry: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package discord
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"teamacedia/discord-bot/internal/verification"
+	"teamacedia/discord-bot/internal/welcome"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleVerifyComponent handles the Verify button and the button under an image captcha
+func handleVerifyComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	pending, err := verification.IsPending(i.Member.User.ID, i.GuildID)
+	if err != nil {
+		replyEphemeral(s, i, "Failed to check your verification: "+err.Error())
+		return
+	}
+	if !pending {
+		replyEphemeral(s, i, "You are already verified.")
+		return
+	}
+
+	switch i.MessageComponentData().CustomID {
+	case verification.StartID:
+		handleVerifyStart(s, i)
+	case verification.AnswerID:
+		openVerifyModal(s, i, "Code from the picture")
+	}
+}
+
+// handleVerifyStart shows a new captcha. Modals can't hold pictures, so image captchas are
+// shown in an ephemeral message with a button that opens the answer modal.
+func handleVerifyStart(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	challenge, err := verification.NewChallenge(i.GuildID, i.Member.User.ID)
+	switch {
+	case errors.Is(err, verification.ErrCooldown):
+		replyEphemeral(s, i, "Please wait a few seconds before asking for a new captcha.")
+		return
+	case errors.Is(err, verification.ErrTooManyAttempts):
+		replyEphemeral(s, i, "You have given too many wrong answers. Please contact a moderator.")
+		return
+	case err != nil:
+		replyEphemeral(s, i, "Failed to create captcha: "+err.Error())
+		return
+	}
+
+	if len(challenge.Image) == 0 {
+		openVerifyModal(s, i, challenge.Prompt)
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:       "Verification",
+				Description: challenge.Prompt + ", then press **Enter code**. Letters are not case sensitive.",
+				Color:       0x00FFFF, // Cyan
+				Image:       &discordgo.MessageEmbedImage{URL: "attachment://captcha.png"},
+			}},
+			Files: []*discordgo.File{{
+				Name:        "captcha.png",
+				ContentType: "image/png",
+				Reader:      bytes.NewReader(challenge.Image),
+			}},
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.Button{Label: "Enter code", Style: discordgo.PrimaryButton, CustomID: verification.AnswerID},
+				}},
+			},
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction with captcha: %v", err)
+	}
+}
+
+// openVerifyModal asks for the captcha answer, label is shown above the input
+func openVerifyModal(s *discordgo.Session, i *discordgo.InteractionCreate, label string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: verification.SubmitID,
+			Title:    "Verification",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.TextInput{
+						CustomID:  verification.InputID,
+						Label:     label,
+						Style:     discordgo.TextInputShort,
+						Required:  true,
+						MinLength: 1,
+						MaxLength: 10,
+					},
+				}},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction with modal: %v", err)
+	}
+}
+
+// handleVerifySubmit checks the answer from the modal
+func handleVerifySubmit(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ModalSubmitInteractionData) {
+	answer := data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
+
+	ok, err := verification.Check(s, i.GuildID, i.Member.User, answer)
+	switch {
+	case errors.Is(err, verification.ErrNoChallenge):
+		replyEphemeral(s, i, "Your captcha expired. Press **Verify** for a new one.")
+	case errors.Is(err, verification.ErrTooManyAttempts):
+		replyEphemeral(s, i, "That's not right, and you have given too many wrong answers.")
+	case err != nil:
+		replyEphemeral(s, i, "Failed to verify: "+err.Error())
+	case !ok:
+		replyEphemeral(s, i, "That's not right. Press **Verify** to try again with a new captcha.")
+	default:
+		replyEphemeral(s, i, "You are verified, welcome!")
+		welcome.OnVerified(s, i.GuildID, i.Member.User)
+	}
+}
@@ -0,0 +1,96 @@
+package discord
+
+import (
+	"fmt"
+	"log"
+	"teamacedia/discord-bot/internal/sticky_roles"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// humanDuration formats a duration in days, hours and minutes, like 12d 4h or 35m
+func humanDuration(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// timestampOr formats a time as a Discord timestamp, or returns fallback for the zero time
+func timestampOr(t time.Time, fallback string) string {
+	if t.IsZero() {
+		return fallback
+	}
+	return fmt.Sprintf("<t:%d:f> (<t:%d:R>)", t.Unix(), t.Unix())
+}
+
+func handleWhoisCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if !isAdmin(i.Member) {
+		replyEphemeral(s, i, "You do not have permission to use this command.")
+		return
+	}
+
+	user := data.Options[0].UserValue(s)
+
+	history, err := sticky_roles.GetJoinHistory(user.ID, i.GuildID)
+	if err != nil {
+		replyEphemeral(s, i, "Failed to load join history: "+err.Error())
+		return
+	}
+	record, err := sticky_roles.GetRecord(user.ID, i.GuildID)
+	if err != nil {
+		replyEphemeral(s, i, "Failed to load join history: "+err.Error())
+		return
+	}
+
+	joins := fmt.Sprintf("%d", history.Joins)
+	// Members from before join history was recorded have no join event yet
+	if history.Joins == 0 && record.Joined {
+		joins = "Joined before join history was recorded"
+	}
+
+	status := "Not in the server"
+	if _, err := s.GuildMember(i.GuildID, user.ID); err == nil {
+		status = "In the server"
+	}
+
+	created, _ := discordgo.SnowflakeTimestamp(user.ID)
+	embed := &discordgo.MessageEmbed{
+		Title: "Who Is " + user.Username,
+		Color: 0x00FFFF, // Cyan
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: user.AvatarURL(""),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "User", Value: fmt.Sprintf("<@%s> (%s)", user.ID, user.ID), Inline: true},
+			{Name: "Status", Value: status, Inline: true},
+			{Name: "Account Created", Value: timestampOr(created, "Unknown"), Inline: false},
+			{Name: "Joins", Value: joins, Inline: true},
+			{Name: "Leaves", Value: fmt.Sprintf("%d", history.Leaves), Inline: true},
+			{Name: "Time As Member", Value: humanDuration(history.MemberFor), Inline: true},
+			{Name: "First Joined", Value: timestampOr(history.FirstJoined, "Never"), Inline: false},
+			{Name: "Last Joined", Value: timestampOr(history.LastJoined, "Never"), Inline: false},
+			{Name: "Last Left", Value: timestampOr(history.LastLeft, "Never"), Inline: false},
+		},
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction with embed: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// FillCircle fills a circle centered at cx, cy
+func FillCircle(img *image.RGBA, cx, cy, r int, c color.Color) {
+	for y := -r; y <= r; y++ {
+		for x := -r; x <= r; x++ {
+			if x*x+y*y <= r*r {
+				img.Set(cx+x, cy+y, c)
+			}
+		}
+	}
+}
+
+// DrawCircleImage scales src to size x size and draws it cropped to a circle, with its
+// top left corner at x, y. Scaling picks the nearest pixel, which is plenty for avatars.
+func DrawCircleImage(img *image.RGBA, src image.Image, x, y, size int) {
+	b := src.Bounds()
+	r := size / 2
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			dx, dy := px-r, py-r
+			if dx*dx+dy*dy > r*r {
+				continue
+			}
+			sx := b.Min.X + px*b.Dx()/size
+			sy := b.Min.Y + py*b.Dy()/size
+			img.Set(x+px, y+py, src.At(sx, sy))
+		}
+	}
+}
+
+// Fill paints the whole image with a vertical gradient from top to bottom
+func Fill(img *image.RGBA, top, bottom color.RGBA) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		t := float64(y-b.Min.Y) / float64(max(b.Dy()-1, 1))
+		c := color.RGBA{
+			R: lerp(top.R, bottom.R, t),
+			G: lerp(top.G, bottom.G, t),
+			B: lerp(top.B, bottom.B, t),
+			A: 0xff,
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			img.Set(x, y, c)
+		}
+	}
+}
+
+func lerp(a, b uint8, t float64) uint8 {
+	return uint8(float64(a) + (float64(b)-float64(a))*t)
+}
@@ -0,0 +1,127 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Glyph size of the bitmap font in pixels, before scaling
+const (
+	GlyphWidth  = 5
+	GlyphHeight = 7
+	glyphGap    = 1 // blank columns between two glyphs
+)
+
+// glyphs is a 5x7 bitmap font covering upper-case letters, digits and common punctuation.
+// Lower-case text is drawn in upper case.
+var glyphs = map[rune][GlyphHeight]string{
+	'A':  {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'B':  {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+	'C':  {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D':  {"####.", "#...#", "#...#", "#...#", "#...#", "#...#", "####."},
+	'E':  {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F':  {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'G':  {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".####"},
+	'H':  {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I':  {".###.", "..#..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'J':  {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K':  {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L':  {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M':  {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N':  {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'O':  {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'P':  {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'Q':  {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+	'R':  {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'S':  {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+	'T':  {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U':  {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'V':  {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+	'W':  {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X':  {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y':  {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'Z':  {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+	'0':  {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1':  {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2':  {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3':  {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4':  {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5':  {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6':  {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7':  {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8':  {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9':  {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	' ':  {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+	'.':  {".....", ".....", ".....", ".....", ".....", ".##..", ".##.."},
+	',':  {".....", ".....", ".....", ".....", ".##..", "..#..", ".#..."},
+	'!':  {"..#..", "..#..", "..#..", "..#..", "..#..", ".....", "..#.."},
+	'?':  {".###.", "#...#", "....#", "...#.", "..#..", ".....", "..#.."},
+	'-':  {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	'+':  {".....", "..#..", "..#..", "#####", "..#..", "..#..", "....."},
+	'=':  {".....", ".....", "#####", ".....", "#####", ".....", "....."},
+	':':  {".....", ".##..", ".##..", ".....", ".##..", ".##..", "....."},
+	'\'': {"..#..", "..#..", ".#...", ".....", ".....", ".....", "....."},
+	'"':  {".#.#.", ".#.#.", ".....", ".....", ".....", ".....", "....."},
+	'/':  {".....", "....#", "...#.", "..#..", ".#...", "#....", "....."},
+	'(':  {"...#.", "..#..", ".#...", ".#...", ".#...", "..#..", "...#."},
+	')':  {".#...", "..#..", "...#.", "...#.", "...#.", "..#..", ".#..."},
+	'#':  {".#.#.", ".#.#.", "#####", ".#.#.", "#####", ".#.#.", ".#.#."},
+	'@':  {".###.", "#...#", "#.###", "#.#.#", "#.###", "#....", ".####"},
+	'&':  {".##..", "#..#.", "#.#..", ".#...", "#.#.#", "#..#.", ".##.#"},
+	'_':  {".....", ".....", ".....", ".....", ".....", ".....", "#####"},
+}
+
+// unknownGlyph is drawn for characters the font doesn't have
+var unknownGlyph = [GlyphHeight]string{"#####", "#...#", "#...#", "#...#", "#...#", "#...#", "#####"}
+
+// glyphFor returns the bitmap of a character
+func glyphFor(r rune) [GlyphHeight]string {
+	if g, ok := glyphs[r]; ok {
+		return g
+	}
+	if g, ok := glyphs[[]rune(strings.ToUpper(string(r)))[0]]; ok {
+		return g
+	}
+	return unknownGlyph
+}
+
+// TextWidth returns how many pixels wide text is when drawn at the given scale
+func TextWidth(text string, scale int) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return (n*(GlyphWidth+glyphGap) - glyphGap) * scale
+}
+
+// DrawGlyph draws a single character with its top left corner at x, y. Every font pixel
+// becomes a scale x scale square.
+func DrawGlyph(img *image.RGBA, x, y int, r rune, scale int, c color.Color) {
+	for row, line := range glyphFor(r) {
+		for col, px := range line {
+			if px != '#' {
+				continue
+			}
+			fillRect(img, x+col*scale, y+row*scale, scale, scale, c)
+		}
+	}
+}
+
+// DrawText draws text on a single line with its top left corner at x, y
+func DrawText(img *image.RGBA, x, y int, text string, scale int, c color.Color) {
+	for _, r := range text {
+		DrawGlyph(img, x, y, r, scale, c)
+		x += (GlyphWidth + glyphGap) * scale
+	}
+}
+
+// fillRect fills a rectangle, clipped to the image bounds
+func fillRect(img *image.RGBA, x, y, w, h int, c color.Color) {
+	rect := image.Rect(x, y, x+w, y+h).Intersect(img.Bounds())
+	for py := rect.Min.Y; py < rect.Max.Y; py++ {
+		for px := rect.Min.X; px < rect.Max.X; px++ {
+			img.Set(px, py, c)
+		}
+	}
+}
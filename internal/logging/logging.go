@@ -2,6 +2,7 @@ package logging
 
 import (
 	"fmt"
+	"sync"
 	"teamacedia/discord-bot/internal/anonimize"
 	"teamacedia/discord-bot/internal/config"
 	"time"
@@ -15,7 +16,45 @@ type CachedMessage struct {
 	Author   string
 }
 
-var messageCache = make(map[string]CachedMessage)
+var (
+	cacheMu      sync.Mutex
+	messageCache = make(map[string]CachedMessage)
+)
+
+// cacheMessage remembers a message so edits and deletes can show what it said
+func cacheMessage(id string, msg CachedMessage) (previous CachedMessage, ok bool) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	previous, ok = messageCache[id]
+	messageCache[id] = msg
+	return previous, ok
+}
+
+// takeCachedMessage returns a cached message and forgets it
+func takeCachedMessage(id string) (CachedMessage, bool) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	msg, ok := messageCache[id]
+	delete(messageCache, id)
+	return msg, ok
+}
+
+// ForgetUser drops every cached message of a user and returns how many there were
+func ForgetUser(userID string) int {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	count := 0
+	for id, msg := range messageCache {
+		if msg.AuthorID == userID {
+			delete(messageCache, id)
+			count++
+		}
+	}
+	return count
+}
 
 // Message Create Handler
 func OnMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -29,11 +68,11 @@ func OnMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
-	messageCache[m.ID] = CachedMessage{
+	cacheMessage(m.ID, CachedMessage{
 		Content:  m.Content,
 		AuthorID: m.Author.ID,
 		Author:   fmt.Sprintf("<@%s> (%s#%s)", m.Author.ID, m.Author.Username, m.Author.Discriminator),
-	}
+	})
 }
 
 // Message Update Handler
@@ -51,16 +90,14 @@ func OnMessageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
 		clickableLink = fmt.Sprintf("[%s](%s)", channelName, messageLink)
 	}
 
-	oldMsg, ok := messageCache[m.ID]
-	oldContent := ""
-	if ok {
-		oldContent = oldMsg.Content
-	}
-
-	messageCache[m.ID] = CachedMessage{
+	oldMsg, ok := cacheMessage(m.ID, CachedMessage{
 		Content:  m.Content,
 		AuthorID: m.Author.ID,
 		Author:   fmt.Sprintf("<@%s> (%s#%s)", m.Author.ID, m.Author.Username, m.Author.Discriminator),
+	})
+	oldContent := ""
+	if ok {
+		oldContent = oldMsg.Content
 	}
 
 	embed := &discordgo.MessageEmbed{
@@ -80,11 +117,9 @@ func OnMessageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
 
 // Message Delete Handler
 func OnMessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
-	cached, ok := messageCache[m.ID]
+	cached, ok := takeCachedMessage(m.ID)
 	if !ok {
 		return
-	} else {
-		delete(messageCache, m.ID)
 	}
 
 	channel, _ := s.Channel(m.ChannelID)
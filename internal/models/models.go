@@ -1,24 +1,263 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
 type Config struct {
-	Token                  string
-	AppID                  string
-	GuildID                string
-	ReactionRolesChannelID string
-	ReactionRoles          []ReactionRole
-	LogChannelID           string
-	MemberRoleID           string
-	AnonWebhook            string
-	AnonChannelID          string
+	Token                   string
+	AppID                   string
+	GuildID                 string
+	ReactionRolesChannelID  string
+	ReactionRoles           []ReactionRole
+	LogChannelID            string
+	MemberRoleID            string
+	AnonChannels            []AnonChannel
+	AnonKey                 string
+	AdminRoleID             string
+	StickyAllowRoleIDs      []string // only these roles are restored when set
+	StickyDenyRoleIDs       []string // never restored
+	StickyModerationRoleIDs []string // kept when members delete their data, e.g. a muted role
+	StickyRetentionDays     int      // forget members this many days after they left, 0 keeps them forever
+	DefaultRoleIDs          []string // given to new members, MemberRoleID when empty
+	DefaultBotRoleIDs       []string // given to new bots instead of DefaultRoleIDs
+	DefaultRoleDelay        time.Duration
+	VerificationChannelID   string        // new members must verify here before getting their default roles
+	VerificationCaptcha     string        // CaptchaImage or CaptchaText
+	VerificationTimeout     time.Duration // kick members who haven't verified by then, 0 never kicks
+	RulesChannelID          string
+	WelcomeChannelID        string // empty posts no welcome messages
+	WelcomeMessage          string
+	WelcomeDM               string // sent to the new member, empty sends no DM
+	WelcomeBanner           bool   // attach a generated banner with the member's avatar
+	GoodbyeChannelID        string // empty posts no goodbye messages
+	GoodbyeMessage          string
 }
 
+// Verification captcha kinds
+const (
+	CaptchaImage = "image" // read a code from a generated picture
+	CaptchaText  = "text"  // answer a simple sum
+)
+
 type ReactionRole struct {
 	ID    string
 	Name  string
-	Emoji string
+	Emoji Emoji
+	Group string // name of the panel group the role belongs to, if any
+
+	Duration time.Duration // how long the role is kept once picked, 0 for no expiry
+
+	RequiredRoles []string // members need at least one of these to pick the role
+	BlockedRoles  []string // members holding any of these can't pick the role
+}
+
+// Emoji is a unicode emoji, or a custom emoji when ID is set
+type Emoji struct {
+	Name     string
+	ID       string
+	Animated bool
+}
+
+// MessageFormat returns the emoji as it is written in message content
+func (e Emoji) MessageFormat() string {
+	if e.ID == "" {
+		return e.Name
+	}
+	if e.Animated {
+		return "<a:" + e.Name + ":" + e.ID + ">"
+	}
+	return "<:" + e.Name + ":" + e.ID + ">"
+}
+
+// APIName returns the emoji as the reaction endpoints expect it
+func (e Emoji) APIName() string {
+	if e.ID == "" {
+		return e.Name
+	}
+	return e.Name + ":" + e.ID
+}
+
+// Matches reports whether a reaction emoji is this emoji. Custom emojis are matched
+// on ID, so same-named emojis from other servers don't count.
+func (e Emoji) Matches(name, id string) bool {
+	if e.ID != "" {
+		return id == e.ID
+	}
+	return id == "" && name == e.Name
+}
+
+var customEmojiRegex = regexp.MustCompile(`^<(a?):(\w{2,32}):(\d+)>$`)
+var apiEmojiRegex = regexp.MustCompile(`^(\w{2,32}):(\d+)$`)
+
+// ParseEmoji parses a unicode emoji, a custom emoji as written in messages (<:name:id> or <a:name:id>),
+// or a custom emoji in the name:id form used by the API
+func ParseEmoji(data string) (Emoji, error) {
+	data = strings.TrimSpace(data)
+
+	if m := customEmojiRegex.FindStringSubmatch(data); m != nil {
+		return Emoji{Name: m[2], ID: m[3], Animated: m[1] == "a"}, nil
+	}
+	if m := apiEmojiRegex.FindStringSubmatch(data); m != nil {
+		return Emoji{Name: m[1], ID: m[2]}, nil
+	}
+
+	if data == "" {
+		return Emoji{}, errors.New("emoji is empty")
+	}
+	// Unicode emojis only use ASCII for keycaps like #️⃣ and 1️⃣, anything else is a
+	// shortcode like :smile: or text that Discord won't accept as a reaction
+	isText := func(r rune) bool { return r < 0x80 && r != '#' && r != '*' && (r < '0' || r > '9') }
+	if strings.IndexFunc(data, isText) >= 0 {
+		return Emoji{}, fmt.Errorf("invalid emoji %q, use the emoji itself or <:name:id> for custom emojis", data)
+	}
+	return Emoji{Name: data}, nil
+}
+
+// TempRole is a picked role that is removed again once it expires
+type TempRole struct {
+	GuildID   string
+	UserID    string
+	RoleID    string
+	ExpiresAt time.Time
+}
+
+// Role event actions
+const (
+	RoleEventAdd    = "add"
+	RoleEventRemove = "remove"
+	RoleEventExpire = "expire"
+)
+
+// RoleEvent records a panel role being picked, dropped or running out
+type RoleEvent struct {
+	UserID    string
+	RoleID    string
+	Action    string
+	CreatedAt time.Time
+}
+
+// RoleStats summarizes how a panel role is used over a period
+type RoleStats struct {
+	Panel    string
+	RoleID   string
+	RoleName string
+	Holders  int
+	Added    int
+	Removed  int
+	Expired  int
+	Trend    []RoleTrend // changes per period, oldest first
+}
+
+// RoleTrend counts the changes of a panel role within one period of RoleStats
+type RoleTrend struct {
+	Start   time.Time
+	Added   int
+	Removed int
+	Expired int
+}
+
+// OnboardingEntry is a new member still waiting for their default roles
+type OnboardingEntry struct {
+	UserID            string
+	GuildID           string
+	AssignAt          time.Time
+	AwaitingScreening bool // membership screening must be passed before the delay starts
+}
+
+// Verification is a new member who hasn't passed the verification gate yet
+type Verification struct {
+	UserID   string
+	GuildID  string
+	JoinedAt time.Time
+	Attempts int // wrong answers so far
+}
+
+// RoleGroup limits how many of a panel's roles in the group a member may hold. Max 1 makes it a "pick one" group.
+type RoleGroup struct {
+	Name string
+	Max  int
 }
 
+// RolePanel is a reaction-role message. RolesHash fingerprints what the panel was last
+// rendered with, so the message and its reactions are only updated when it changes.
+type RolePanel struct {
+	ID          int64
+	Name        string
+	ChannelID   string
+	MessageID   string
+	RolesHash   string
+	Title       string
+	Description string
+	Color       int
+	Style       string
+	MaxRoles    int // most roles of the panel a member may hold, 0 for no limit
+	Roles       []ReactionRole
+	Groups      []RoleGroup
+}
+
+// Role panel styles
+const (
+	PanelStyleReactions = "reactions" // members react with the role's emoji
+	PanelStyleButtons   = "buttons"   // one button per role
+	PanelStyleSelect    = "select"    // a multi-select menu listing every role
+)
+
 type Reminder struct {
 	UserID string
 	Text   string
 }
+
+// Anonymous channel modes
+const (
+	AnonModeNamed     = "named"     // repost with the author's name and avatar
+	AnonModeAnonymous = "anonymous" // repost as a single shared "Anonymous" identity
+	AnonModePseudonym = "pseudonym" // repost under a stable per-author pseudonym
+)
+
+// AnonChannel configures one anonymous channel. WebhookURL is optional, the bot
+// creates and manages a webhook for the channel when it is empty.
+type AnonChannel struct {
+	ChannelID       string
+	WebhookURL      string
+	Mode            string
+	PseudonymScheme string
+	Filter          AnonFilter
+}
+
+// AnonFilter holds the abuse controls applied to anonymous messages
+type AnonFilter struct {
+	RateLimit          int
+	RateWindow         time.Duration
+	Blocklist          []*regexp.Regexp
+	AllowedAttachments []string
+	MaxAttachmentSize  int64
+}
+
+// AnonMessage maps a message reposted by the anon webhook to its original author.
+// SealedAuthor holds the author's user ID encrypted with the configured AnonKey.
+type AnonMessage struct {
+	MessageID    string
+	ChannelID    string
+	SealedAuthor string
+	CreatedAt    time.Time
+}
+
+// AnonBan blocks a user from anonymous channels. A zero ExpiresAt means the ban is permanent.
+type AnonBan struct {
+	UserID    string
+	BannedBy  string
+	Reason    string
+	ExpiresAt time.Time
+}
+
+// AnonWebhook is a webhook the bot created to repost messages in an anonymous channel
+type AnonWebhook struct {
+	ChannelID string
+	WebhookID string
+	Token     string
+}
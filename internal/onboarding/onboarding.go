@@ -0,0 +1,240 @@
+package onboarding
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const schedulerInterval = 30 * time.Second
+
+// defaultRoles returns the roles a new member should get. Bots get the same roles as
+// humans unless bot roles are configured.
+func defaultRoles(user *discordgo.User) []string {
+	if user.Bot && len(config.Config.DefaultBotRoleIDs) > 0 {
+		return config.Config.DefaultBotRoleIDs
+	}
+	return config.Config.DefaultRoleIDs
+}
+
+// OnFirstJoin gives a first-time joiner their default roles, or queues them if a delay
+// is configured or Discord's membership screening is still pending. With verification
+// enabled humans get nothing until they pass it, see OnVerified.
+func OnFirstJoin(s *discordgo.Session, m *discordgo.Member) {
+	if !m.User.Bot && config.Config.VerificationChannelID != "" {
+		err := db.AddVerification(models.Verification{
+			UserID:   m.User.ID,
+			GuildID:  m.GuildID,
+			JoinedAt: time.Now(),
+		})
+		if err != nil {
+			log.Printf("Failed to add %s to verification: %v", m.User.Username, err)
+			return
+		}
+		log.Printf("Waiting for %s to verify before giving default roles", m.User.Username)
+		return
+	}
+
+	schedule(s, m.GuildID, m.User, m.Pending)
+}
+
+// OnVerified gives a member who passed verification their default roles, after the delay if one is set
+func OnVerified(s *discordgo.Session, guildID string, user *discordgo.User) {
+	schedule(s, guildID, user, false)
+}
+
+// OnRejoin resumes onboarding for a returning member who left before verifying or before
+// getting their default roles
+func OnRejoin(s *discordgo.Session, m *discordgo.Member) {
+	// Unverified members, including those who were kicked, start over with a new deadline and attempts
+	v, err := db.GetVerification(m.User.ID, m.GuildID)
+	if err == nil && config.Config.VerificationChannelID == "" {
+		// Verification was turned off since they left, let them in like any new member
+		if err := db.DeleteVerification(m.User.ID, m.GuildID); err != nil {
+			log.Printf("Failed to remove verification of %s: %v", m.User.Username, err)
+		}
+		schedule(s, m.GuildID, m.User, m.Pending)
+		return
+	}
+	if err == nil {
+		v.JoinedAt = time.Now()
+		v.Attempts = 0
+		if err := db.AddVerification(v); err != nil {
+			log.Printf("Failed to add %s to verification: %v", m.User.Username, err)
+			return
+		}
+		log.Printf("%s came back without having verified, waiting for them to verify", m.User.Username)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("DB error while fetching verification of %s: %v", m.User.Username, err)
+		return
+	}
+
+	_, err = db.GetOnboardingEntry(m.User.ID, m.GuildID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("DB error while fetching onboarding entry for %s: %v", m.User.Username, err)
+		return
+	}
+
+	log.Printf("%s came back before getting their default roles", m.User.Username)
+	schedule(s, m.GuildID, m.User, m.Pending)
+}
+
+// schedule gives a member their default roles now or queues them for later
+func schedule(s *discordgo.Session, guildID string, user *discordgo.User, pending bool) {
+	if len(defaultRoles(user)) == 0 {
+		return
+	}
+
+	// Bots skip screening and the delay, which are meant for humans
+	if user.Bot || (!pending && config.Config.DefaultRoleDelay <= 0) {
+		AssignDefaults(s, guildID, user)
+		return
+	}
+
+	entry := models.OnboardingEntry{
+		UserID:            user.ID,
+		GuildID:           guildID,
+		AssignAt:          time.Now().Add(config.Config.DefaultRoleDelay),
+		AwaitingScreening: pending,
+	}
+	if err := db.SetOnboardingEntry(entry); err != nil {
+		log.Printf("Failed to queue default roles for %s: %v", user.Username, err)
+		return
+	}
+
+	if pending {
+		log.Printf("Waiting for %s to pass membership screening before giving default roles", user.Username)
+	} else {
+		log.Printf("Default roles for %s are due at %s", user.Username, entry.AssignAt.Format(time.RFC3339))
+	}
+}
+
+// OnMemberUpdate starts the delay, or gives the default roles, once a queued member passes membership screening.
+func OnMemberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+	if m.Pending {
+		return
+	}
+
+	entry, err := db.GetOnboardingEntry(m.User.ID, m.GuildID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !entry.AwaitingScreening) {
+		return
+	}
+	if err != nil {
+		log.Printf("DB error while fetching onboarding entry for %s: %v", m.User.Username, err)
+		return
+	}
+
+	if config.Config.DefaultRoleDelay <= 0 {
+		AssignDefaults(s, m.GuildID, m.User)
+		return
+	}
+
+	entry.AwaitingScreening = false
+	entry.AssignAt = time.Now().Add(config.Config.DefaultRoleDelay)
+	if err := db.SetOnboardingEntry(entry); err != nil {
+		log.Printf("Failed to queue default roles for %s: %v", m.User.Username, err)
+	}
+}
+
+// AssignDefaults gives a member their default roles right away and removes them from the queue.
+// Members who got none of them are kept in the queue, so the next scheduler run tries again.
+func AssignDefaults(s *discordgo.Session, guildID string, user *discordgo.User) {
+	roles := defaultRoles(user)
+	given := 0
+	for _, roleID := range roles {
+		if err := s.GuildMemberRoleAdd(guildID, user.ID, roleID); err != nil {
+			log.Printf("Failed to add default role %s to %s: %v", roleID, user.Username, err)
+			continue
+		}
+		given++
+	}
+	if given == 0 && len(roles) > 0 {
+		entry := models.OnboardingEntry{UserID: user.ID, GuildID: guildID, AssignAt: time.Now()}
+		if err := db.SetOnboardingEntry(entry); err != nil {
+			log.Printf("Failed to queue default roles for %s: %v", user.Username, err)
+		}
+		return
+	}
+	log.Printf("Assigned %d default roles to new member: %s", given, user.Username)
+
+	if err := db.DeleteOnboardingEntry(user.ID, guildID); err != nil {
+		log.Printf("Failed to remove %s from the onboarding queue: %v", user.Username, err)
+	}
+}
+
+// StartScheduler gives queued members their default roles once they are due.
+// The queue is stored in the database, so pending entries survive restarts.
+func StartScheduler(s *discordgo.Session) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	assignDue(s)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-ticker.C:
+			assignDue(s)
+		case <-sigs:
+			return
+		}
+	}
+}
+
+// assignDue gives every due member their default roles
+func assignDue(s *discordgo.Session) {
+	entries, err := db.GetDueOnboardingEntries(time.Now())
+	if err != nil {
+		log.Printf("Failed to load onboarding queue: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		member, err := s.GuildMember(entry.GuildID, entry.UserID)
+
+		// Members who left in the meantime get their roles if they come back, see OnRejoin
+		var restErr *discordgo.RESTError
+		if errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound {
+			if err := db.SetOnboardingDeparted(entry.UserID, entry.GuildID); err != nil {
+				log.Printf("Failed to mark %s as departed in the onboarding queue: %v", entry.UserID, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to fetch queued member %s: %v", entry.UserID, err)
+			continue
+		}
+
+		AssignDefaults(s, entry.GuildID, member.User)
+	}
+}
+
+// ForgetDeparted drops the queue entries and pending verifications of departed members whose
+// data was purged. They count as new members when they join again, so they still have to verify.
+func ForgetDeparted(guildID string, userIDs []string) {
+	for _, userID := range userIDs {
+		if err := db.DeleteDepartedOnboarding(userID, guildID); err != nil {
+			log.Printf("Failed to remove departed member %s from onboarding: %v", userID, err)
+		}
+		if err := db.DeleteDepartedVerification(userID, guildID); err != nil {
+			log.Printf("Failed to remove verification of departed member %s: %v", userID, err)
+		}
+	}
+}
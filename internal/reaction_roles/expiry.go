@@ -0,0 +1,114 @@
+package reaction_roles
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+	"teamacedia/discord-bot/internal/sticky_roles"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const expiryInterval = time.Minute
+
+// durationLabel formats a role duration in the largest whole unit, like 24h or 7d
+func durationLabel(d time.Duration) string {
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"w", 7 * 24 * time.Hour},
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+	}
+	for _, u := range units {
+		if d%u.size == 0 {
+			return fmt.Sprintf("%d%s", d/u.size, u.suffix)
+		}
+	}
+	return d.String()
+}
+
+// trackExpiry stores when a timed role that was just given runs out, returning the zero time for permanent roles
+func trackExpiry(guildID, userID string, rr models.ReactionRole) time.Time {
+	if rr.Duration <= 0 {
+		return time.Time{}
+	}
+
+	expiresAt := time.Now().Add(rr.Duration)
+	err := db.AddTempRole(models.TempRole{GuildID: guildID, UserID: userID, RoleID: rr.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		log.Printf("failed to store expiry of role %s for %s: %v", rr.ID, userID, err)
+	}
+	return expiresAt
+}
+
+// forgetExpiry drops stored expiries of roles the member gave up themselves
+func forgetExpiry(userID string, roleIDs []string) {
+	for _, roleID := range roleIDs {
+		if err := db.DeleteTempRole(userID, roleID); err != nil {
+			log.Printf("failed to clear expiry of role %s for %s: %v", roleID, userID, err)
+		}
+	}
+}
+
+// StartExpiry removes timed roles once they expire. Expiries are stored in the database,
+// so roles that ran out while the bot was offline are removed on startup.
+func StartExpiry(s *discordgo.Session) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	RemoveExpiredRoles(s)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-ticker.C:
+			RemoveExpiredRoles(s)
+		case <-sigs:
+			return
+		}
+	}
+}
+
+// RemoveExpiredRoles takes away every timed role that has run out
+func RemoveExpiredRoles(s *discordgo.Session) {
+	roles, err := db.GetExpiredTempRoles(time.Now())
+	if err != nil {
+		log.Printf("failed to load expired roles: %v", err)
+		return
+	}
+
+	for _, r := range roles {
+		err := s.GuildMemberRoleRemove(r.GuildID, r.UserID, r.RoleID)
+
+		// A member who left or a deleted role has nothing left to remove, but a member who left
+		// still has the role stored and would get it back when they join again
+		var restErr *discordgo.RESTError
+		if errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound {
+			err = sticky_roles.DropRole(r.UserID, r.GuildID, r.RoleID)
+		}
+		if err != nil {
+			// Kept in the database so the next run retries
+			log.Printf("failed to remove expired role %s from %s: %v", r.RoleID, r.UserID, err)
+			continue
+		}
+
+		if err := db.DeleteTempRole(r.UserID, r.RoleID); err != nil {
+			log.Printf("failed to clear expiry of role %s for %s: %v", r.RoleID, r.UserID, err)
+			continue
+		}
+		recordEvents(r.UserID, models.RoleEventExpire, []string{r.RoleID})
+		log.Printf("Removed expired role %s from %s", r.RoleID, r.UserID)
+	}
+}
@@ -0,0 +1,152 @@
+package reaction_roles
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	ErrGroupNotFound = errors.New("no group with that name exists on this panel, create it with `/rolepanel group`")
+	ErrGroupName     = errors.New("group names can't be empty")
+)
+
+// panelGroup returns the group with the given name
+func panelGroup(panel models.RolePanel, name string) (models.RoleGroup, bool) {
+	idx := slices.IndexFunc(panel.Groups, func(g models.RoleGroup) bool { return g.Name == name })
+	if idx < 0 {
+		return models.RoleGroup{}, false
+	}
+	return panel.Groups[idx], true
+}
+
+// groupRule describes a group's limit for the panel embed
+func groupRule(group models.RoleGroup) string {
+	if group.Max == 1 {
+		return "pick one"
+	}
+	return fmt.Sprintf("pick up to %d", group.Max)
+}
+
+// planToggle works out which roles to add and remove when a member toggles rr.
+// Picking a role in a "pick one" group swaps out the other roles of that group.
+// If a limit would be exceeded, nothing changes and the reason is returned instead.
+func planToggle(panel models.RolePanel, held []string, rr models.ReactionRole) (add, remove []string, refusal string) {
+	if slices.Contains(held, rr.ID) {
+		return nil, []string{rr.ID}, ""
+	}
+
+	if group, ok := panelGroup(panel, rr.Group); ok {
+		var inGroup []string
+		for _, other := range panel.Roles {
+			if other.Group == group.Name && slices.Contains(held, other.ID) {
+				inGroup = append(inGroup, other.ID)
+			}
+		}
+
+		if group.Max == 1 {
+			remove = inGroup
+		} else if len(inGroup) >= group.Max {
+			return nil, nil, fmt.Sprintf("You can have at most %d roles from **%s**. Remove one of them first.", group.Max, group.Name)
+		}
+	}
+
+	if panel.MaxRoles > 0 {
+		count := 0
+		for _, other := range panel.Roles {
+			if slices.Contains(held, other.ID) && !slices.Contains(remove, other.ID) {
+				count++
+			}
+		}
+		if count >= panel.MaxRoles {
+			return nil, nil, fmt.Sprintf("You can have at most %d roles from this panel. Remove one of them first.", panel.MaxRoles)
+		}
+	}
+
+	return []string{rr.ID}, remove, ""
+}
+
+// checkSelection explains why a select menu choice breaks the panel's limits, or returns an empty string
+func checkSelection(panel models.RolePanel, selected []string) string {
+	if panel.MaxRoles > 0 && len(selected) > panel.MaxRoles {
+		return fmt.Sprintf("You can pick at most %d roles from this panel.", panel.MaxRoles)
+	}
+
+	for _, group := range panel.Groups {
+		count := 0
+		for _, rr := range panel.Roles {
+			if rr.Group == group.Name && slices.Contains(selected, rr.ID) {
+				count++
+			}
+		}
+		if count <= group.Max {
+			continue
+		}
+		if group.Max == 1 {
+			return fmt.Sprintf("You can only pick one role from **%s**.", group.Name)
+		}
+		return fmt.Sprintf("You can pick at most %d roles from **%s**.", group.Max, group.Name)
+	}
+
+	return ""
+}
+
+// SetGroup creates or updates a group of a panel. A max of 0 removes the group and leaves its roles ungrouped.
+func (st *State) SetGroup(s *discordgo.Session, name string, group models.RoleGroup) error {
+	st.cmdMu.Lock()
+	defer st.cmdMu.Unlock()
+
+	if strings.TrimSpace(group.Name) == "" {
+		return ErrGroupName
+	}
+
+	panel, err := st.editablePanel(name)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := panelGroup(panel, group.Name); !ok && group.Max == 0 {
+		return ErrGroupNotFound
+	}
+
+	panel.Groups = slices.DeleteFunc(slices.Clone(panel.Groups), func(g models.RoleGroup) bool { return g.Name == group.Name })
+	panel.Roles = slices.Clone(panel.Roles)
+
+	if group.Max == 0 {
+		if err := db.DeleteRolePanelGroup(panel.ID, group.Name); err != nil {
+			return err
+		}
+		for i := range panel.Roles {
+			if panel.Roles[i].Group == group.Name {
+				panel.Roles[i].Group = ""
+			}
+		}
+	} else {
+		if err := db.SetRolePanelGroup(panel.ID, group); err != nil {
+			return err
+		}
+		panel.Groups = append(panel.Groups, group)
+		slices.SortFunc(panel.Groups, func(a, b models.RoleGroup) int { return strings.Compare(a.Name, b.Name) })
+	}
+
+	return st.syncPanel(s, &panel)
+}
+
+// SetLimit sets the most roles a member may hold from a panel, 0 for no limit
+func (st *State) SetLimit(s *discordgo.Session, name string, limit int) error {
+	st.cmdMu.Lock()
+	defer st.cmdMu.Unlock()
+
+	panel, err := st.editablePanel(name)
+	if err != nil {
+		return err
+	}
+
+	panel.MaxRoles = limit
+	return st.syncPanel(s, &panel)
+}
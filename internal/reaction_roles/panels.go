@@ -0,0 +1,364 @@
+package reaction_roles
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord allows at most 20 different reactions on a message, which also keeps
+// button and select panels well within their 25 component limit
+const maxPanelRoles = 20
+
+var (
+	ErrPanelNotFound = errors.New("no panel with that name exists")
+	ErrPanelExists   = errors.New("a panel with that name already exists")
+	ErrConfigPanel   = errors.New("this panel is managed by config.ini")
+	ErrPanelFull     = fmt.Errorf("a panel can hold at most %d roles", maxPanelRoles)
+	ErrEmojiInUse    = errors.New("another role on this panel already uses that emoji")
+)
+
+// roleLine describes one role in the panel embed
+func roleLine(rr models.ReactionRole) string {
+	if rr.Duration > 0 {
+		return fmt.Sprintf("%s - %s (for %s)\n", rr.Emoji.MessageFormat(), rr.Name, durationLabel(rr.Duration))
+	}
+	return fmt.Sprintf("%s - %s\n", rr.Emoji.MessageFormat(), rr.Name)
+}
+
+// panelEmbed creates an embed listing all roles of a panel
+func panelEmbed(panel models.RolePanel) *discordgo.MessageEmbed {
+	desc := ""
+	if panel.Description != "" {
+		desc = panel.Description + "\n\n"
+	}
+	for _, rr := range panel.Roles {
+		if _, ok := panelGroup(panel, rr.Group); !ok {
+			desc += roleLine(rr)
+		}
+	}
+	for _, group := range panel.Groups {
+		lines := ""
+		for _, rr := range panel.Roles {
+			if rr.Group == group.Name {
+				lines += roleLine(rr)
+			}
+		}
+		if lines != "" {
+			desc += fmt.Sprintf("\n**%s** (%s)\n%s", group.Name, groupRule(group), lines)
+		}
+	}
+	if panel.MaxRoles > 0 {
+		desc += fmt.Sprintf("\nYou can pick up to %d roles from this panel.", panel.MaxRoles)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       panel.Title,
+		Description: desc,
+		Color:       panel.Color,
+	}
+}
+
+// componentPrefix starts the custom ID of every panel button and select menu
+const componentPrefix = "rr:"
+
+// panelComponents creates the buttons or select menu of a panel, or nothing for reaction panels
+func panelComponents(panel models.RolePanel) []discordgo.MessageComponent {
+	if len(panel.Roles) == 0 {
+		return []discordgo.MessageComponent{}
+	}
+
+	switch panel.Style {
+	case models.PanelStyleButtons:
+		// Discord fits at most 5 buttons in a row
+		var rows []discordgo.MessageComponent
+		for start := 0; start < len(panel.Roles); start += 5 {
+			row := discordgo.ActionsRow{}
+			for _, rr := range panel.Roles[start:min(start+5, len(panel.Roles))] {
+				row.Components = append(row.Components, discordgo.Button{
+					Label:    rr.Name,
+					Style:    discordgo.SecondaryButton,
+					Emoji:    componentEmoji(rr.Emoji),
+					CustomID: fmt.Sprintf("%s%d:%s", componentPrefix, panel.ID, rr.ID),
+				})
+			}
+			rows = append(rows, row)
+		}
+		return rows
+
+	case models.PanelStyleSelect:
+		menu := panelMenu(panel, fmt.Sprintf("%s%d", componentPrefix, panel.ID), nil)
+		return []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{menu}}}
+	}
+
+	return []discordgo.MessageComponent{}
+}
+
+// memberMenuID ends the custom ID of the menu showing a member their own roles of a panel
+const memberMenuID = "member"
+
+// panelMenu creates a select menu of a panel's roles with the held ones preselected
+func panelMenu(panel models.RolePanel, customID string, held []string) discordgo.SelectMenu {
+	minValues := 0
+	menu := discordgo.SelectMenu{
+		CustomID:    customID,
+		Placeholder: "Choose your roles",
+		MinValues:   &minValues,
+		MaxValues:   len(panel.Roles),
+	}
+	if panel.MaxRoles > 0 {
+		menu.MaxValues = min(panel.MaxRoles, len(panel.Roles))
+	}
+	for _, rr := range panel.Roles {
+		menu.Options = append(menu.Options, discordgo.SelectMenuOption{
+			Label:   rr.Name,
+			Value:   rr.ID,
+			Emoji:   componentEmoji(rr.Emoji),
+			Default: slices.Contains(held, rr.ID),
+		})
+	}
+	return menu
+}
+
+// panelHash fingerprints everything a panel is rendered from so changes can be detected between restarts
+func panelHash(panel models.RolePanel) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s\x00%d\x00", panel.Title, panel.Description, panel.Color, panel.Style, panel.MaxRoles)
+	for _, rr := range panel.Roles {
+		fmt.Fprintf(h, "%s,%s,%s,%s,%d|", rr.ID, rr.Name, rr.Emoji.MessageFormat(), rr.Group, rr.Duration)
+	}
+	for _, g := range panel.Groups {
+		fmt.Fprintf(h, "%s,%d;", g.Name, g.Max)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// componentEmoji converts an emoji for use on buttons and select menu options
+func componentEmoji(e models.Emoji) *discordgo.ComponentEmoji {
+	return &discordgo.ComponentEmoji{Name: e.Name, ID: e.ID, Animated: e.Animated}
+}
+
+// reconcileReactions removes reactions for emojis that are no longer configured and adds missing ones
+func reconcileReactions(s *discordgo.Session, msg *discordgo.Message, roles []models.ReactionRole) {
+	present := make([]bool, len(roles))
+	for _, reaction := range msg.Reactions {
+		idx := slices.IndexFunc(roles, func(rr models.ReactionRole) bool {
+			return rr.Emoji.Matches(reaction.Emoji.Name, reaction.Emoji.ID)
+		})
+		if idx < 0 {
+			name := reaction.Emoji.APIName()
+			if err := s.MessageReactionsRemoveEmoji(msg.ChannelID, msg.ID, name); err != nil {
+				log.Printf("failed to remove stale reaction %s: %v", name, err)
+			}
+			continue
+		}
+		if reaction.Me {
+			present[idx] = true
+		}
+	}
+
+	for idx, rr := range roles {
+		if present[idx] {
+			continue
+		}
+		if err := s.MessageReactionAdd(msg.ChannelID, msg.ID, rr.Emoji.APIName()); err != nil {
+			log.Printf("failed to add reaction %s: %v", rr.Emoji.MessageFormat(), err)
+		}
+	}
+}
+
+// syncPanel reuses the panel's message if it still exists, updating it when the panel changed,
+// or posts a new one. The panel is saved and watched afterwards.
+func (st *State) syncPanel(s *discordgo.Session, panel *models.RolePanel) error {
+	hash := panelHash(*panel)
+
+	if panel.MessageID != "" {
+		msg, err := s.ChannelMessage(panel.ChannelID, panel.MessageID)
+		if err == nil {
+			if panel.RolesHash != hash {
+				embeds := []*discordgo.MessageEmbed{panelEmbed(*panel)}
+				components := panelComponents(*panel)
+				_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+					ID:         msg.ID,
+					Channel:    panel.ChannelID,
+					Embeds:     &embeds,
+					Components: &components,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to update embed: %w", err)
+				}
+				if panel.Style == models.PanelStyleReactions {
+					reconcileReactions(s, msg, panel.Roles)
+				}
+
+				panel.RolesHash = hash
+				if _, err := db.SaveRolePanel(*panel); err != nil {
+					return err
+				}
+				log.Printf("Updated reaction role panel %s", panel.Name)
+			}
+
+			st.register(panel)
+			return nil
+		}
+		log.Printf("Reaction role panel %s is gone, posting a new one", panel.Name)
+	}
+
+	msg, err := s.ChannelMessageSendComplex(panel.ChannelID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{panelEmbed(*panel)},
+		Components: panelComponents(*panel),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send embed: %w", err)
+	}
+
+	if panel.Style == models.PanelStyleReactions {
+		for _, rr := range panel.Roles {
+			if err := s.MessageReactionAdd(panel.ChannelID, msg.ID, rr.Emoji.APIName()); err != nil {
+				log.Printf("failed to add reaction %s: %v", rr.Emoji.MessageFormat(), err)
+			}
+		}
+	}
+
+	panel.MessageID = msg.ID
+	panel.RolesHash = hash
+	panel.ID, err = db.SaveRolePanel(*panel)
+	if err != nil {
+		return err
+	}
+
+	st.register(panel)
+	return nil
+}
+
+// CreatePanel posts a new empty panel and starts watching it
+func (st *State) CreatePanel(s *discordgo.Session, panel models.RolePanel) error {
+	st.cmdMu.Lock()
+	defer st.cmdMu.Unlock()
+
+	_, err := db.GetRolePanel(panel.Name)
+	if err == nil {
+		return ErrPanelExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return st.syncPanel(s, &panel)
+}
+
+// editablePanel looks up a panel that may be changed with commands
+func (st *State) editablePanel(name string) (models.RolePanel, error) {
+	if name == configPanelName {
+		return models.RolePanel{}, ErrConfigPanel
+	}
+	panel, ok := st.panelByName(name)
+	if !ok {
+		return panel, ErrPanelNotFound
+	}
+	return panel, nil
+}
+
+// AddRole adds a role to a panel, or updates it if the role is already listed
+func (st *State) AddRole(s *discordgo.Session, name string, role models.ReactionRole) error {
+	st.cmdMu.Lock()
+	defer st.cmdMu.Unlock()
+
+	panel, err := st.editablePanel(name)
+	if err != nil {
+		return err
+	}
+
+	if role.Group != "" {
+		if _, ok := panelGroup(panel, role.Group); !ok {
+			return ErrGroupNotFound
+		}
+	}
+
+	// Reactions are matched by emoji, so a second role with the same one could never be picked
+	if slices.ContainsFunc(panel.Roles, func(rr models.ReactionRole) bool {
+		return rr.ID != role.ID && rr.Emoji.Matches(role.Emoji.Name, role.Emoji.ID)
+	}) {
+		return ErrEmojiInUse
+	}
+
+	roles := slices.Clone(panel.Roles)
+	idx := slices.IndexFunc(roles, func(rr models.ReactionRole) bool { return rr.ID == role.ID })
+	if idx >= 0 {
+		// Restrictions are managed separately and survive changing the emoji or group
+		role.RequiredRoles = roles[idx].RequiredRoles
+		role.BlockedRoles = roles[idx].BlockedRoles
+		roles[idx] = role
+	} else {
+		if len(roles) >= maxPanelRoles {
+			return ErrPanelFull
+		}
+		roles = append(roles, role)
+	}
+
+	return st.saveRoles(s, panel, roles)
+}
+
+// RemoveRole removes a role from a panel
+func (st *State) RemoveRole(s *discordgo.Session, name, roleID string) error {
+	st.cmdMu.Lock()
+	defer st.cmdMu.Unlock()
+
+	panel, err := st.editablePanel(name)
+	if err != nil {
+		return err
+	}
+
+	roles := slices.DeleteFunc(slices.Clone(panel.Roles), func(rr models.ReactionRole) bool { return rr.ID == roleID })
+	if len(roles) == len(panel.Roles) {
+		return errors.New("that role is not on this panel")
+	}
+
+	return st.saveRoles(s, panel, roles)
+}
+
+// saveRoles stores a panel's new role list and updates its message
+func (st *State) saveRoles(s *discordgo.Session, panel models.RolePanel, roles []models.ReactionRole) error {
+	if err := db.SetRolePanelRoles(panel.ID, roles); err != nil {
+		return err
+	}
+	panel.Roles = roles
+	return st.syncPanel(s, &panel)
+}
+
+// DeletePanel removes a panel, its message and its stored roles
+func (st *State) DeletePanel(s *discordgo.Session, name string) error {
+	st.cmdMu.Lock()
+	defer st.cmdMu.Unlock()
+
+	// Panels whose message couldn't be posted aren't watched but can still be deleted
+	panel, ok := st.panelByName(name)
+	if !ok {
+		var err error
+		panel, err = db.GetRolePanel(name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPanelNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := db.DeleteRolePanel(panel.ID); err != nil {
+		return err
+	}
+	st.unregister(panel.ID)
+
+	if err := s.ChannelMessageDelete(panel.ChannelID, panel.MessageID); err != nil {
+		log.Printf("failed to delete message of reaction role panel %s: %v", panel.Name, err)
+	}
+	return nil
+}
@@ -1,65 +1,304 @@
 package reaction_roles
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/db"
 	"teamacedia/discord-bot/internal/models"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-// State holds the posted message ID so we know what to watch
+// State holds every posted panel keyed by message ID so we know what to watch
 type State struct {
-	MessageID string
-	Roles     []models.ReactionRole
+	mu     sync.RWMutex
+	panels map[string]*models.RolePanel
+
+	// cmdMu serializes panel changes so concurrent commands can't race each other
+	cmdMu sync.Mutex
+
+	// claims remembers recently handled reactions, see claimReaction
+	claimsMu sync.Mutex
+	claims   map[string]claim
 }
 
-// InitReactionRoles clears the channel, posts an embed, adds reactions, and returns state
+// configPanelName is the stored name of the panel built from the ReactionRoles config
+const configPanelName = "config"
+
+// InitReactionRoles syncs the config panel into the database, reuses or reposts every stored panel,
+// applies reactions missed while offline and returns state
 func InitReactionRoles(s *discordgo.Session, roles []models.ReactionRole) (*State, error) {
-	if len(roles) == 0 {
-		return nil, fmt.Errorf("no roles provided")
+	state := &State{
+		panels: make(map[string]*models.RolePanel),
+		claims: make(map[string]claim),
 	}
 
-	channelID := config.Config.ReactionRolesChannelID
+	if len(roles) > 0 {
+		if err := saveConfigPanel(roles); err != nil {
+			return nil, fmt.Errorf("failed to save reaction roles panel: %w", err)
+		}
+	}
+
+	panels, err := db.GetRolePanels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reaction role panels: %w", err)
+	}
 
-	// 1. Delete all existing messages in the channel
-	msgs, err := s.ChannelMessages(channelID, 100, "", "", "")
-	if err == nil {
-		for _, m := range msgs {
-			_ = s.ChannelMessageDelete(channelID, m.ID)
+	for i := range panels {
+		if err := state.syncPanel(s, &panels[i]); err != nil {
+			log.Printf("failed to set up reaction role panel %s: %v", panels[i].Name, err)
 		}
 	}
 
-	// 2. Create an embed listing all roles
-	desc := ""
-	for _, rr := range roles {
-		desc += fmt.Sprintf("%s - %s\n", rr.Emoji, rr.Name)
+	// Catch up on reactions added while the bot was offline
+	state.Reconcile(s)
+
+	return state, nil
+}
+
+// saveConfigPanel stores the panel described by the ReactionRoles config
+func saveConfigPanel(roles []models.ReactionRole) error {
+	panel, err := db.GetRolePanel(configPanelName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		panel = models.RolePanel{
+			Name:  configPanelName,
+			Title: "Reaction Roles",
+			Color: 0x00FFFF, // Cyan
+			Style: models.PanelStyleReactions,
+		}
 	}
 
-	embed := &discordgo.MessageEmbed{
-		Title:       "Reaction Roles",
-		Description: desc,
-		Color:       0x00FFFF, // Cyan
+	// Moving the panel to another channel means posting a new message there
+	if panel.ChannelID != config.Config.ReactionRolesChannelID {
+		panel.ChannelID = config.Config.ReactionRolesChannelID
+		panel.MessageID = ""
 	}
 
-	msg, err := s.ChannelMessageSendEmbed(channelID, embed)
+	panel.ID, err = db.SaveRolePanel(panel)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send embed: %w", err)
+		return err
+	}
+	return db.SetRolePanelRoles(panel.ID, roles)
+}
+
+// register starts watching a panel under its current message ID
+func (st *State) register(panel *models.RolePanel) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for messageID, p := range st.panels {
+		if p.ID == panel.ID {
+			delete(st.panels, messageID)
+		}
+	}
+	st.panels[panel.MessageID] = panel
+}
+
+// unregister stops watching a panel
+func (st *State) unregister(panelID int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for messageID, p := range st.panels {
+		if p.ID == panelID {
+			delete(st.panels, messageID)
+		}
+	}
+}
+
+// panelByMessage returns a copy of the panel posted as the given message
+func (st *State) panelByMessage(messageID string) (models.RolePanel, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	panel, ok := st.panels[messageID]
+	if !ok {
+		return models.RolePanel{}, false
+	}
+	return *panel, true
+}
+
+// panelByName returns a copy of the panel with the given name
+func (st *State) panelByName(name string) (models.RolePanel, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	for _, p := range st.panels {
+		if p.Name == name {
+			return *p, true
+		}
+	}
+	return models.RolePanel{}, false
+}
+
+// Panels returns a copy of every panel, oldest first
+func (st *State) Panels() []models.RolePanel {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	panels := make([]models.RolePanel, 0, len(st.panels))
+	for _, p := range st.panels {
+		panels = append(panels, *p)
+	}
+	slices.SortFunc(panels, func(a, b models.RolePanel) int {
+		return int(a.ID - b.ID)
+	})
+	return panels
+}
+
+// panelByID returns a copy of the panel with the given ID
+func (st *State) panelByID(id int64) (models.RolePanel, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	for _, p := range st.panels {
+		if p.ID == id {
+			return *p, true
+		}
+	}
+	return models.RolePanel{}, false
+}
+
+// mentionRoles formats role IDs as mentions
+func mentionRoles(roleIDs []string) string {
+	mentions := make([]string, len(roleIDs))
+	for i, id := range roleIDs {
+		mentions[i] = fmt.Sprintf("<@&%s>", id)
+	}
+	return strings.Join(mentions, ", ")
+}
+
+// toggleRole gives the member the role if they don't have it, or removes it, and describes what happened.
+// Group and panel limits are respected, and a refusal is described rather than returned as an error.
+func toggleRole(s *discordgo.Session, guildID string, member *discordgo.Member, panel models.RolePanel, rr models.ReactionRole) (string, error) {
+	userID := member.User.ID
+
+	refusal := ""
+	if !slices.Contains(member.Roles, rr.ID) {
+		refusal = checkRestrictions(member.Roles, rr)
+	}
+
+	var add, remove []string
+	if refusal == "" {
+		add, remove, refusal = planToggle(panel, member.Roles, rr)
+	}
+	if refusal != "" {
+		return fmt.Sprintf("<@%s> %s", userID, refusal), nil
+	}
+
+	for _, roleID := range remove {
+		if err := s.GuildMemberRoleRemove(guildID, userID, roleID); err != nil {
+			return "", err
+		}
 	}
+	for _, roleID := range add {
+		if err := s.GuildMemberRoleAdd(guildID, userID, roleID); err != nil {
+			return "", err
+		}
+	}
+
+	forgetExpiry(userID, remove)
+	recordEvents(userID, models.RoleEventRemove, remove)
+	recordEvents(userID, models.RoleEventAdd, add)
+
+	if len(add) == 0 {
+		return fmt.Sprintf("<@%s> I have removed the role <@&%s> from you.", userID, rr.ID), nil
+	}
+
+	given := fmt.Sprintf("<@%s> I have given the role <@&%s> to you", userID, rr.ID)
+	if expiresAt := trackExpiry(guildID, userID, rr); !expiresAt.IsZero() {
+		given += fmt.Sprintf(" until <t:%d:f>", expiresAt.Unix())
+	}
+	if len(remove) > 0 {
+		return fmt.Sprintf("%s and removed %s, as you can only pick one role from **%s**.", given, mentionRoles(remove), rr.Group), nil
+	}
+	return given + ".", nil
+}
 
-	// 3. Add reactions to the message
-	for _, rr := range roles {
-		if err := s.MessageReactionAdd(channelID, msg.ID, rr.Emoji); err != nil {
-			log.Printf("failed to add reaction %s: %v", rr.Emoji, err)
+// selectRoles gives the member the selected roles out of the panel's roles. The shared panel menu doesn't know what
+// the member holds, so only with exact set are unselected roles removed. It describes what changed and returns the
+// member's roles afterwards, which on error covers the changes made before it.
+func selectRoles(s *discordgo.Session, guildID string, member *discordgo.Member, panel models.RolePanel, selected []string, exact bool) (string, []string, error) {
+	held := slices.Clone(member.Roles)
+	if !exact {
+		for _, rr := range panel.Roles {
+			if slices.Contains(held, rr.ID) && !slices.Contains(selected, rr.ID) {
+				selected = append(selected, rr.ID)
+			}
+		}
+	}
+
+	refusal := checkSelection(panel, selected)
+	for _, rr := range panel.Roles {
+		if refusal != "" {
+			break
+		}
+		if slices.Contains(selected, rr.ID) && !slices.Contains(held, rr.ID) {
+			refusal = checkRestrictions(held, rr)
+		}
+	}
+	if refusal != "" {
+		return refusal + " Your roles were not changed.", held, nil
+	}
+
+	userID := member.User.ID
+	var added, removed, expiries []string
+
+	describe := func() string {
+		description := ""
+		if len(added) > 0 {
+			description += "I have given you: " + mentionRoles(added) + "\n"
+		}
+		if len(removed) > 0 {
+			description += "I have removed: " + mentionRoles(removed) + "\n"
+		}
+		for _, expiry := range expiries {
+			description += expiry + "\n"
+		}
+		return description
+	}
+
+	for _, rr := range panel.Roles {
+		has := slices.Contains(held, rr.ID)
+		want := slices.Contains(selected, rr.ID)
+
+		switch {
+		case want && !has:
+			if err := s.GuildMemberRoleAdd(guildID, userID, rr.ID); err != nil {
+				return describe(), held, err
+			}
+			held = append(held, rr.ID)
+			added = append(added, rr.ID)
+			recordEvents(userID, models.RoleEventAdd, []string{rr.ID})
+			if expiresAt := trackExpiry(guildID, userID, rr); !expiresAt.IsZero() {
+				expiries = append(expiries, fmt.Sprintf("<@&%s> expires <t:%d:R>.", rr.ID, expiresAt.Unix()))
+			}
+		case has && !want:
+			if err := s.GuildMemberRoleRemove(guildID, userID, rr.ID); err != nil {
+				return describe(), held, err
+			}
+			held = slices.DeleteFunc(held, func(id string) bool { return id == rr.ID })
+			removed = append(removed, rr.ID)
+			recordEvents(userID, models.RoleEventRemove, []string{rr.ID})
+			forgetExpiry(userID, []string{rr.ID})
 		}
 	}
 
-	return &State{
-		MessageID: msg.ID,
-		Roles:     roles,
-	}, nil
+	if len(added) == 0 && len(removed) == 0 {
+		return "Your roles are already up to date.", held, nil
+	}
+	return describe(), held, nil
 }
 
 // HandleReactionAdd toggles a role, removes the user's reaction, and sends a temporary embed
@@ -68,37 +307,31 @@ func HandleReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd, st
 		return
 	}
 
-	if r.MessageID != state.MessageID {
+	panel, ok := state.panelByMessage(r.MessageID)
+	if !ok {
 		return
 	}
 
-	for _, rr := range state.Roles {
-		if r.Emoji.Name == rr.Emoji {
+	for _, rr := range panel.Roles {
+		if rr.Emoji.Matches(r.Emoji.Name, r.Emoji.ID) {
+			// Already applied by a reconcile run that saw the reaction first
+			if !state.claimReaction(r.MessageID, r.UserID, rr.Emoji, false) {
+				return
+			}
+
 			// Get member to check roles
 			member, err := s.GuildMember(r.GuildID, r.UserID)
 			if err != nil {
 				break
 			}
 
-			hasRole := false
-			for _, roleID := range member.Roles {
-				if roleID == rr.ID {
-					hasRole = true
-					break
-				}
-			}
-
-			var description string
-			if hasRole {
-				_ = s.GuildMemberRoleRemove(r.GuildID, r.UserID, rr.ID)
-				description = fmt.Sprintf("<@%s> I have removed the role <@&%s> from you.", r.UserID, rr.ID)
-			} else {
-				_ = s.GuildMemberRoleAdd(r.GuildID, r.UserID, rr.ID)
-				description = fmt.Sprintf("<@%s> I have given the role <@&%s> to you.", r.UserID, rr.ID)
+			description, err := toggleRole(s, r.GuildID, member, panel, rr)
+			if err != nil {
+				log.Printf("failed to toggle role %s for %s: %v", rr.ID, r.UserID, err)
+				description = fmt.Sprintf("<@%s> I could not update the role <@&%s> for you.", r.UserID, rr.ID)
 			}
 
-			// Remove user's reaction
-			_ = s.MessageReactionRemove(r.ChannelID, r.MessageID, r.Emoji.Name, r.UserID)
+			removeReaction(s, panel, rr.Emoji, r.UserID)
 
 			// Send a temporary embed message
 			embed := &discordgo.MessageEmbed{
@@ -123,5 +356,62 @@ func HandleReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd, st
 	}
 
 	// Remove user's reaction
-	_ = s.MessageReactionRemove(r.ChannelID, r.MessageID, r.Emoji.Name, r.UserID)
+	_ = s.MessageReactionRemove(r.ChannelID, r.MessageID, r.Emoji.APIName(), r.UserID)
+}
+
+// IsPanelComponent reports whether a component custom ID belongs to a role panel
+func IsPanelComponent(customID string) bool {
+	return strings.HasPrefix(customID, componentPrefix)
+}
+
+// HandleComponent applies a panel button press or menu selection and returns the confirmation to show the member.
+// Menu selections also return a menu of the member's own roles of the panel, which they can use to remove roles.
+func (st *State) HandleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) (string, []discordgo.MessageComponent, error) {
+	data := i.MessageComponentData()
+
+	// Custom IDs look like rr:PANELID for menus, rr:PANELID:member for a member's own menu
+	// and rr:PANELID:ROLEID for buttons
+	parts := strings.Split(strings.TrimPrefix(data.CustomID, componentPrefix), ":")
+	panelID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid panel component %q", data.CustomID)
+	}
+
+	panel, ok := st.panelByID(panelID)
+	if !ok {
+		return "", nil, ErrPanelNotFound
+	}
+
+	if data.ComponentType == discordgo.SelectMenuComponent {
+		exact := len(parts) == 2 && parts[1] == memberMenuID
+		description, held, err := selectRoles(s, i.GuildID, i.Member, panel, data.Values, exact)
+		return description, memberMenu(panel, held), err
+	}
+
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("invalid panel component %q", data.CustomID)
+	}
+	idx := slices.IndexFunc(panel.Roles, func(rr models.ReactionRole) bool { return rr.ID == parts[1] })
+	if idx < 0 {
+		return "", nil, errors.New("that role is no longer on this panel")
+	}
+	description, err := toggleRole(s, i.GuildID, i.Member, panel, panel.Roles[idx])
+	return description, nil, err
+}
+
+// memberMenu creates the menu of a member's own roles of a panel
+func memberMenu(panel models.RolePanel, held []string) []discordgo.MessageComponent {
+	menu := panelMenu(panel, fmt.Sprintf("%s%d:%s", componentPrefix, panel.ID, memberMenuID), held)
+
+	// Discord refuses menus with more options preselected than can be picked
+	selected := 0
+	for _, option := range menu.Options {
+		if option.Default {
+			selected++
+		}
+	}
+	if selected > menu.MaxValues {
+		return nil
+	}
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{menu}}}
 }
@@ -0,0 +1,198 @@
+package reaction_roles
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	reconcileInterval = 10 * time.Minute
+
+	// claimWindow is how long a reaction handled by one path is ignored by the other,
+	// so a reaction seen by both the live handler and a reconcile run only toggles once
+	claimWindow = time.Minute
+)
+
+type claim struct {
+	at        time.Time
+	reconcile bool
+}
+
+// claimReaction reports whether a reaction should be handled, or was already handled by the other path
+func (st *State) claimReaction(messageID, userID string, emoji models.Emoji, reconcile bool) bool {
+	st.claimsMu.Lock()
+	defer st.claimsMu.Unlock()
+
+	now := time.Now()
+	for key, c := range st.claims {
+		if now.Sub(c.at) > claimWindow {
+			delete(st.claims, key)
+		}
+	}
+
+	key := messageID + "/" + userID + "/" + emoji.APIName()
+	if c, ok := st.claims[key]; ok && c.reconcile != reconcile {
+		return false
+	}
+	st.claims[key] = claim{at: now, reconcile: reconcile}
+	return true
+}
+
+// StartReconciler brings every panel back in line with its reactions on a timer
+func (st *State) StartReconciler(s *discordgo.Session) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-ticker.C:
+			st.Reconcile(s)
+		case <-sigs:
+			return
+		}
+	}
+}
+
+// Reconcile reposts missing panels, applies reactions added while the bot wasn't listening,
+// removes them again and restores missing bot reactions
+func (st *State) Reconcile(s *discordgo.Session) {
+	for _, panel := range st.Panels() {
+		if err := st.reconcilePanel(s, panel.ID); err != nil {
+			log.Printf("failed to reconcile reaction role panel %s: %v", panel.Name, err)
+		}
+	}
+}
+
+func (st *State) reconcilePanel(s *discordgo.Session, panelID int64) error {
+	// Reposts the panel if its message was deleted. The panel is looked up again
+	// under the lock, as a command may have changed or deleted it meanwhile.
+	st.cmdMu.Lock()
+	panel, ok := st.panelByID(panelID)
+	if !ok {
+		st.cmdMu.Unlock()
+		return nil
+	}
+	err := st.syncPanel(s, &panel)
+	st.cmdMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if panel.Style != models.PanelStyleReactions {
+		return nil
+	}
+
+	for _, rr := range panel.Roles {
+		users, err := reactionUsers(s, panel, rr.Emoji)
+		if err != nil {
+			log.Printf("failed to list %s reactions on panel %s: %v", rr.Emoji.MessageFormat(), panel.Name, err)
+			continue
+		}
+
+		stuck, err := db.GetStuckReactions(panel.MessageID, rr.Emoji.APIName())
+		if err != nil {
+			log.Printf("failed to load stuck reactions on panel %s: %v", panel.Name, err)
+			continue
+		}
+
+		for _, user := range users {
+			if user.Bot {
+				continue
+			}
+			// Already applied, only the reaction couldn't be removed
+			if stuck[user.ID] {
+				delete(stuck, user.ID)
+				if s.MessageReactionRemove(panel.ChannelID, panel.MessageID, rr.Emoji.APIName(), user.ID) == nil {
+					forgetStuckReaction(panel, rr.Emoji, user.ID)
+				}
+				continue
+			}
+			st.applyMissedReaction(s, panel, rr, user.ID)
+		}
+
+		// Removed by the member or a moderator meanwhile
+		for userID := range stuck {
+			forgetStuckReaction(panel, rr.Emoji, userID)
+		}
+	}
+
+	// Reactions cleared by a moderator are added back, stale ones removed
+	msg, err := s.ChannelMessage(panel.ChannelID, panel.MessageID)
+	if err != nil {
+		return err
+	}
+	reconcileReactions(s, msg, panel.Roles)
+	return nil
+}
+
+// reactionUsers lists everyone who reacted to a panel with the emoji
+func reactionUsers(s *discordgo.Session, panel models.RolePanel, emoji models.Emoji) ([]*discordgo.User, error) {
+	var all []*discordgo.User
+	after := "" // used for pagination
+	limit := 100
+
+	for {
+		users, err := s.MessageReactions(panel.ChannelID, panel.MessageID, emoji.APIName(), limit, "", after)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, users...)
+
+		if len(users) < limit {
+			return all, nil
+		}
+		after = users[len(users)-1].ID
+	}
+}
+
+// applyMissedReaction toggles the role for a reaction nobody handled yet and removes the reaction
+func (st *State) applyMissedReaction(s *discordgo.Session, panel models.RolePanel, rr models.ReactionRole, userID string) {
+	if !st.claimReaction(panel.MessageID, userID, rr.Emoji, true) {
+		return
+	}
+
+	member, err := s.GuildMember(config.Config.GuildID, userID)
+	if err == nil {
+		description, err := toggleRole(s, config.Config.GuildID, member, panel, rr)
+		if err != nil {
+			log.Printf("failed to toggle role %s for %s: %v", rr.ID, userID, err)
+		} else {
+			log.Printf("Applied missed reaction on panel %s: %s", panel.Name, description)
+		}
+	}
+
+	// Members who left still have their reaction cleared
+	removeReaction(s, panel, rr.Emoji, userID)
+}
+
+// removeReaction removes a handled reaction. Reactions that can't be removed are remembered,
+// so later reconcile runs don't apply them again.
+func removeReaction(s *discordgo.Session, panel models.RolePanel, emoji models.Emoji, userID string) {
+	err := s.MessageReactionRemove(panel.ChannelID, panel.MessageID, emoji.APIName(), userID)
+	if err == nil {
+		return
+	}
+	log.Printf("failed to remove reaction %s of %s: %v", emoji.MessageFormat(), userID, err)
+
+	if err := db.AddStuckReaction(panel.MessageID, emoji.APIName(), userID); err != nil {
+		log.Printf("failed to remember stuck reaction %s of %s: %v", emoji.MessageFormat(), userID, err)
+	}
+}
+
+// forgetStuckReaction drops a remembered reaction that is gone now
+func forgetStuckReaction(panel models.RolePanel, emoji models.Emoji, userID string) {
+	if err := db.DeleteStuckReaction(panel.MessageID, emoji.APIName(), userID); err != nil {
+		log.Printf("failed to forget stuck reaction %s of %s: %v", emoji.MessageFormat(), userID, err)
+	}
+}
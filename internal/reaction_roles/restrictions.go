@@ -0,0 +1,75 @@
+package reaction_roles
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"teamacedia/discord-bot/internal/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Restriction kinds that can be put on a panel role
+const (
+	RestrictRequire = "require"
+	RestrictBlock   = "block"
+)
+
+// checkRestrictions explains why a member holding the given roles may not pick rr, or returns an empty string
+func checkRestrictions(held []string, rr models.ReactionRole) string {
+	if len(rr.RequiredRoles) > 0 && !slices.ContainsFunc(rr.RequiredRoles, func(id string) bool { return slices.Contains(held, id) }) {
+		if len(rr.RequiredRoles) == 1 {
+			return fmt.Sprintf("You need the role %s to pick <@&%s>.", mentionRoles(rr.RequiredRoles), rr.ID)
+		}
+		return fmt.Sprintf("You need one of the roles %s to pick <@&%s>.", mentionRoles(rr.RequiredRoles), rr.ID)
+	}
+
+	var blocking []string
+	for _, id := range rr.BlockedRoles {
+		if slices.Contains(held, id) {
+			blocking = append(blocking, id)
+		}
+	}
+	if len(blocking) > 0 {
+		return fmt.Sprintf("You can't pick <@&%s> while you have %s.", rr.ID, mentionRoles(blocking))
+	}
+
+	return ""
+}
+
+// ToggleRestriction adds a required or blocked role to a panel role, or removes it if it is already set.
+// It returns the resulting list of required or blocked roles.
+func (st *State) ToggleRestriction(s *discordgo.Session, name, roleID, kind, restrictedID string) ([]string, error) {
+	st.cmdMu.Lock()
+	defer st.cmdMu.Unlock()
+
+	panel, err := st.editablePanel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := slices.Clone(panel.Roles)
+	idx := slices.IndexFunc(roles, func(rr models.ReactionRole) bool { return rr.ID == roleID })
+	if idx < 0 {
+		return nil, errors.New("that role is not on this panel")
+	}
+	if restrictedID == roleID {
+		return nil, errors.New("a role can't restrict itself")
+	}
+
+	list := &roles[idx].RequiredRoles
+	if kind == RestrictBlock {
+		list = &roles[idx].BlockedRoles
+	}
+
+	if slices.Contains(*list, restrictedID) {
+		*list = slices.DeleteFunc(slices.Clone(*list), func(id string) bool { return id == restrictedID })
+	} else {
+		*list = append(slices.Clone(*list), restrictedID)
+	}
+
+	if err := st.saveRoles(s, panel, roles); err != nil {
+		return nil, err
+	}
+	return *list, nil
+}
@@ -0,0 +1,120 @@
+package reaction_roles
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"strconv"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// recordEvents stores a role change for every given role
+func recordEvents(userID, action string, roleIDs []string) {
+	for _, roleID := range roleIDs {
+		err := db.AddRoleEvent(models.RoleEvent{UserID: userID, RoleID: roleID, Action: action, CreatedAt: time.Now()})
+		if err != nil {
+			log.Printf("failed to record %s of role %s for %s: %v", action, roleID, userID, err)
+		}
+	}
+}
+
+// countHolders counts how many members of the guild hold each role
+func countHolders(s *discordgo.Session, guildID string) (map[string]int, error) {
+	holders := make(map[string]int)
+	after := "" // used for pagination
+	limit := 1000
+
+	for {
+		members, err := s.GuildMembers(guildID, after, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range members {
+			for _, roleID := range m.Roles {
+				holders[roleID]++
+			}
+		}
+
+		if len(members) < limit {
+			return holders, nil
+		}
+		after = members[len(members)-1].User.ID
+	}
+}
+
+// Stats returns current holder counts and the changes since the given time for every role
+// on the panels, or on a single panel when name is set. Changes are also split into periods
+// of the given length so trends can be shown.
+func (st *State) Stats(s *discordgo.Session, guildID, name string, since time.Time, period time.Duration) ([]models.RoleStats, error) {
+	panels := st.Panels()
+	if name != "" {
+		panel, ok := st.panelByName(name)
+		if !ok {
+			return nil, ErrPanelNotFound
+		}
+		panels = []models.RolePanel{panel}
+	}
+
+	holders, err := countHolders(s, guildID)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := db.CountRoleEvents(since, period)
+	if err != nil {
+		return nil, err
+	}
+	periods := max(int((time.Since(since)+period-1)/period), 1)
+
+	var stats []models.RoleStats
+	for _, panel := range panels {
+		for _, rr := range panel.Roles {
+			rs := models.RoleStats{
+				Panel:    panel.Name,
+				RoleID:   rr.ID,
+				RoleName: rr.Name,
+				Holders:  holders[rr.ID],
+				Trend:    make([]models.RoleTrend, periods),
+			}
+			for i := range rs.Trend {
+				c := counts[rr.ID][i]
+				rs.Trend[i] = models.RoleTrend{
+					Start:   since.Add(time.Duration(i) * period),
+					Added:   c[models.RoleEventAdd],
+					Removed: c[models.RoleEventRemove],
+					Expired: c[models.RoleEventExpire],
+				}
+				rs.Added += rs.Trend[i].Added
+				rs.Removed += rs.Trend[i].Removed
+				rs.Expired += rs.Trend[i].Expired
+			}
+			stats = append(stats, rs)
+		}
+	}
+	return stats, nil
+}
+
+// WriteStatsCSV writes role statistics as CSV with a header row, one row per role and period
+func WriteStatsCSV(w io.Writer, stats []models.RoleStats) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"panel", "role_id", "role_name", "holders", "period_start", "added", "removed", "expired"}); err != nil {
+		return err
+	}
+	for _, rs := range stats {
+		for _, t := range rs.Trend {
+			record := []string{
+				rs.Panel, rs.RoleID, rs.RoleName, strconv.Itoa(rs.Holders), t.Start.UTC().Format(time.RFC3339),
+				strconv.Itoa(t.Added), strconv.Itoa(t.Removed), strconv.Itoa(t.Expired),
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,100 @@
+package sticky_roles
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Record is everything stored about a member
+type Record struct {
+	Roles        []string
+	Joined       bool
+	Nick         string
+	TimeoutUntil time.Time
+}
+
+// GetRecord returns what is stored about a member
+func GetRecord(userID, guildID string) (Record, error) {
+	var record Record
+	var err error
+
+	record.Roles, err = getStoredRoles(userID, guildID)
+	if err != nil {
+		return record, err
+	}
+	record.Joined, err = hasUserJoined(userID, guildID)
+	if err != nil {
+		return record, err
+	}
+	state, err := getMemberState(userID, guildID)
+	if err != nil {
+		return record, err
+	}
+	record.Nick = state.Nick
+	record.TimeoutUntil = state.TimeoutUntil
+	return record, nil
+}
+
+// Restore gives a member in the guild their stored roles, nickname and timeout back,
+// following the same rules as a rejoin
+func Restore(s *discordgo.Session, guildID, userID string) (restored, failed []string, err error) {
+	member, err := s.GuildMember(guildID, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("member is not in the server: %w", err)
+	}
+
+	stored, err := getStoredRoles(userID, guildID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restoreMemberState(s, guildID, member)
+
+	roles, err := restorableRoles(s, guildID, member.User, stored)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(roles) == 0 {
+		return nil, nil, nil
+	}
+
+	restored, failed = restoreRoles(s, guildID, member, roles)
+	return restored, failed, nil
+}
+
+// Clear deletes a member's stored roles, nickname and timeout
+func Clear(userID, guildID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"sticky_roles", "member_state"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ? AND guild_id = ?", userID, guildID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Forget deletes everything stored about a member except their join history,
+// so they are treated as a new member when they join again
+func Forget(userID, guildID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"sticky_roles", "member_state", "joins"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ? AND guild_id = ?", userID, guildID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
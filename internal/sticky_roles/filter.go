@@ -0,0 +1,79 @@
+package sticky_roles
+
+import (
+	"log"
+	"slices"
+	"teamacedia/discord-bot/internal/config"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// guildRoles returns the roles of a guild, preferring the state cache
+func guildRoles(s *discordgo.Session, guildID string) ([]*discordgo.Role, error) {
+	if guild, err := s.State.Guild(guildID); err == nil && len(guild.Roles) > 0 {
+		return guild.Roles, nil
+	}
+	return s.GuildRoles(guildID)
+}
+
+// botTopPosition returns the position of the bot's highest role, roles at or above it can't be assigned
+func botTopPosition(s *discordgo.Session, guildID string, roles []*discordgo.Role) (int, error) {
+	bot, err := s.GuildMember(guildID, s.State.User.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	top := 0
+	for _, r := range roles {
+		if slices.Contains(bot.Roles, r.ID) && r.Position > top {
+			top = r.Position
+		}
+	}
+	return top, nil
+}
+
+// skipReason explains why a stored role must not be restored, or returns an empty string
+func skipReason(role *discordgo.Role, guildID string, botTop int) string {
+	switch {
+	case role.ID == guildID:
+		return "it is @everyone"
+	case role.Managed:
+		return "it is managed by an integration"
+	case slices.Contains(config.Config.StickyDenyRoleIDs, role.ID):
+		return "it is listed in StickyRolesDeny"
+	case len(config.Config.StickyAllowRoleIDs) > 0 && !slices.Contains(config.Config.StickyAllowRoleIDs, role.ID):
+		return "it is not listed in StickyRolesAllow"
+	case role.Position >= botTop:
+		return "it is not below the bot's highest role"
+	}
+	return ""
+}
+
+// restorableRoles filters stored roles down to the ones that may be given back, logging every skipped role
+func restorableRoles(s *discordgo.Session, guildID string, member *discordgo.User, stored []string) ([]string, error) {
+	roles, err := guildRoles(s, guildID)
+	if err != nil {
+		return nil, err
+	}
+	botTop, err := botTopPosition(s, guildID, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	var restore []string
+	for _, roleID := range stored {
+		idx := slices.IndexFunc(roles, func(r *discordgo.Role) bool { return r.ID == roleID })
+		if idx < 0 {
+			log.Printf("Skipped sticky role %s for %s: the role no longer exists", roleID, member.Username)
+			continue
+		}
+
+		role := roles[idx]
+		if reason := skipReason(role, guildID, botTop); reason != "" {
+			log.Printf("Skipped sticky role %s (%s) for %s: %s", role.Name, role.ID, member.Username, reason)
+			continue
+		}
+		restore = append(restore, roleID)
+	}
+	return restore, nil
+}
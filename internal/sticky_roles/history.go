@@ -0,0 +1,148 @@
+package sticky_roles
+
+import (
+	"fmt"
+	"log"
+	"teamacedia/discord-bot/internal/config"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Member event kinds
+const (
+	eventJoin  = "join"
+	eventLeave = "leave"
+)
+
+// JoinHistory summarizes how often and how long a user has been a member
+type JoinHistory struct {
+	Joins       int
+	Leaves      int
+	FirstJoined time.Time
+	LastJoined  time.Time
+	LastLeft    time.Time
+	MemberFor   time.Duration // total time spent in the guild across all joins
+	InGuild     bool
+}
+
+// recordMemberEvent stores a join or leave with the current time
+func recordMemberEvent(userID, guildID, event string) error {
+	_, err := db.Exec(
+		"INSERT INTO member_events (user_id, guild_id, event, created_at) VALUES (?, ?, ?, ?)",
+		userID, guildID, event, time.Now().Unix(),
+	)
+	return err
+}
+
+// GetJoinHistory replays a user's joins and leaves
+func GetJoinHistory(userID, guildID string) (JoinHistory, error) {
+	var history JoinHistory
+
+	rows, err := db.Query(
+		"SELECT event, created_at FROM member_events WHERE user_id = ? AND guild_id = ? ORDER BY created_at, id",
+		userID, guildID,
+	)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event string
+		var createdAt int64
+		if err := rows.Scan(&event, &createdAt); err != nil {
+			return history, err
+		}
+		at := time.Unix(createdAt, 0)
+
+		switch event {
+		case eventJoin:
+			history.Joins++
+			if history.FirstJoined.IsZero() {
+				history.FirstJoined = at
+			}
+			history.LastJoined = at
+			history.InGuild = true
+		case eventLeave:
+			history.Leaves++
+			history.LastLeft = at
+			if history.InGuild {
+				history.MemberFor += at.Sub(history.LastJoined)
+			}
+			history.InGuild = false
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return history, err
+	}
+
+	if history.InGuild {
+		history.MemberFor += time.Since(history.LastJoined)
+	}
+	return history, nil
+}
+
+// ordinal formats a number as 1st, 2nd, 3rd, 4th...
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// postJoinLog posts a join entry with the member's history to the log channel
+func postJoinLog(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	history, err := GetJoinHistory(m.User.ID, m.GuildID)
+	if err != nil {
+		log.Printf("DB error while fetching join history for %s: %v", m.User.Username, err)
+		return
+	}
+
+	summary := "First join"
+
+	// Members from before join history was recorded only show up in the joins table
+	if history.Joins <= 1 {
+		joinedBefore, err := hasUserJoined(m.User.ID, m.GuildID)
+		if err != nil {
+			log.Printf("DB error while checking join history for %s: %v", m.User.Username, err)
+		} else if joinedBefore {
+			summary = "Rejoined, earlier joins are from before join history was recorded"
+		}
+	}
+
+	if history.Joins > 1 {
+		summary = fmt.Sprintf("Rejoined for the %s time", ordinal(history.Joins-1))
+		if !history.LastLeft.IsZero() {
+			summary += fmt.Sprintf(", last left <t:%d:R>", history.LastLeft.Unix())
+		}
+	}
+
+	created, _ := discordgo.SnowflakeTimestamp(m.User.ID)
+	embed := &discordgo.MessageEmbed{
+		Title: "Member Joined",
+		Color: 0x00ff00,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Member", Value: fmt.Sprintf("<@%s> (%s)", m.User.ID, m.User.Username), Inline: true},
+			{Name: "Account Created", Value: fmt.Sprintf("<t:%d:R>", created.Unix()), Inline: true},
+			{Name: "History", Value: summary, Inline: false},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	_, _ = s.ChannelMessageSendEmbed(config.Config.LogChannelID, embed)
+}
+
+// OnMemberLeave records when a member leaves the guild.
+func OnMemberLeave(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	if err := recordMemberEvent(m.User.ID, m.GuildID, eventLeave); err != nil {
+		log.Printf("DB error while recording leave for %s: %v", m.User.Username, err)
+	}
+}
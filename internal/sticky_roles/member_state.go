@@ -0,0 +1,137 @@
+package sticky_roles
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"teamacedia/discord-bot/internal/config"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// memberState is what besides roles sticks to a member across rejoins
+type memberState struct {
+	Nick         string
+	TimeoutUntil time.Time // zero when not timed out
+}
+
+// stateOf reads the sticky state of a member
+func stateOf(m *discordgo.Member) memberState {
+	state := memberState{Nick: m.Nick}
+	// Discord keeps ended timeouts around, those have nothing left to restore
+	if m.CommunicationDisabledUntil != nil && m.CommunicationDisabledUntil.After(time.Now()) {
+		state.TimeoutUntil = *m.CommunicationDisabledUntil
+	}
+	return state
+}
+
+// active reports whether anything in the state still needs to be applied
+func (st memberState) active() bool {
+	return st.Nick != "" || st.TimeoutUntil.After(time.Now())
+}
+
+// getMemberState returns the stored state of a member, or an empty state if there is none
+func getMemberState(userID, guildID string) (memberState, error) {
+	var state memberState
+	var timeoutUntil int64
+	err := db.QueryRow(
+		"SELECT nick, timeout_until FROM member_state WHERE user_id = ? AND guild_id = ?",
+		userID, guildID,
+	).Scan(&state.Nick, &timeoutUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return memberState{}, nil
+	}
+	if timeoutUntil > 0 {
+		state.TimeoutUntil = time.Unix(timeoutUntil, 0)
+	}
+	return state, err
+}
+
+// storeMemberState saves the state of a member, skipping the write if nothing changed
+func storeMemberState(userID, guildID string, state memberState) error {
+	stored, err := getMemberState(userID, guildID)
+	if err != nil {
+		return err
+	}
+	if sameState(stored, state) {
+		return nil
+	}
+
+	return writeMemberState(db, userID, guildID, state)
+}
+
+// writeMemberState stores the state of a member, or deletes it when nothing is left to restore
+func writeMemberState(ex interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, userID, guildID string, state memberState) error {
+	if !state.active() {
+		_, err := ex.Exec("DELETE FROM member_state WHERE user_id = ? AND guild_id = ?", userID, guildID)
+		return err
+	}
+
+	var timeoutUntil int64
+	if !state.TimeoutUntil.IsZero() {
+		timeoutUntil = state.TimeoutUntil.Unix()
+	}
+	_, err := ex.Exec(
+		"INSERT OR REPLACE INTO member_state (user_id, guild_id, nick, timeout_until) VALUES (?, ?, ?, ?)",
+		userID, guildID, state.Nick, timeoutUntil,
+	)
+	return err
+}
+
+// sameState reports whether two states would restore the same thing
+func sameState(a, b memberState) bool {
+	return a.Nick == b.Nick && a.TimeoutUntil.Unix() == b.TimeoutUntil.Unix()
+}
+
+// restoreMemberState puts back a returning member's nickname and a timeout that hasn't run out yet
+func restoreMemberState(s *discordgo.Session, guildID string, member *discordgo.Member) {
+	userID := member.User.ID
+
+	state, err := getMemberState(userID, guildID)
+	if err != nil {
+		log.Printf("DB error while fetching stored state for %s: %v", member.User.Username, err)
+		return
+	}
+	if !state.active() {
+		return
+	}
+
+	params := &discordgo.GuildMemberParams{Nick: state.Nick}
+	timedOut := state.TimeoutUntil.After(time.Now())
+	if timedOut {
+		params.CommunicationDisabledUntil = &state.TimeoutUntil
+	}
+
+	_, err = s.GuildMemberEdit(guildID, userID, params)
+
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Member", Value: fmt.Sprintf("<@%s> (%s)", userID, member.User.Username), Inline: true},
+	}
+	if state.Nick != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Nickname", Value: state.Nick, Inline: true})
+	}
+	if timedOut {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Timed Out Until", Value: fmt.Sprintf("<t:%d:f>", state.TimeoutUntil.Unix()), Inline: true})
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:     "Sticky Member State Restored",
+		Color:     0x00ff00,
+		Fields:    fields,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if err != nil {
+		log.Printf("Failed to restore nickname and timeout for %s: %v", member.User.Username, err)
+		embed.Title = "Failed To Restore Sticky Member State"
+		embed.Color = 0xff0000
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Error", Value: err.Error(), Inline: false})
+	} else {
+		log.Printf("Restored nickname and timeout for returning member: %s", member.User.Username)
+	}
+
+	_, _ = s.ChannelMessageSendEmbed(config.Config.LogChannelID, embed)
+}
@@ -0,0 +1,171 @@
+package sticky_roles
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"teamacedia/discord-bot/internal/config"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// restoreGrace is how long the outcome of a restore is remembered,
+// as Discord sends the resulting update events asynchronously
+const restoreGrace = 5 * time.Second
+
+// pendingRestore holds the roles a member is expected to have while and shortly after their roles are restored
+type pendingRestore struct {
+	roles []string
+	done  bool
+}
+
+var (
+	restoringMu sync.Mutex
+	restoring   = make(map[string]*pendingRestore)
+)
+
+func restoringKey(userID, guildID string) string {
+	return guildID + "/" + userID
+}
+
+// expectRestore remembers the roles a restore is about to give a member
+func expectRestore(userID, guildID string, roles []string) *pendingRestore {
+	restoringMu.Lock()
+	defer restoringMu.Unlock()
+	p := &pendingRestore{roles: roles}
+	restoring[restoringKey(userID, guildID)] = p
+	return p
+}
+
+// finishRestore records the roles a restore ended with and forgets them after restoreGrace
+func finishRestore(userID, guildID string, p *pendingRestore, roles []string) {
+	restoringMu.Lock()
+	defer restoringMu.Unlock()
+	p.roles = roles
+	p.done = true
+
+	key := restoringKey(userID, guildID)
+	time.AfterFunc(restoreGrace, func() {
+		restoringMu.Lock()
+		defer restoringMu.Unlock()
+		if restoring[key] == p {
+			delete(restoring, key)
+		}
+	})
+}
+
+// fromRestore reports whether a member update only shows the progress or outcome of a restore.
+// Any other update ends the expectation, so it and later changes are stored as usual.
+func fromRestore(userID, guildID string, roles []string) bool {
+	restoringMu.Lock()
+	defer restoringMu.Unlock()
+
+	key := restoringKey(userID, guildID)
+	p, ok := restoring[key]
+	if !ok {
+		return false
+	}
+
+	// Roles are added one at a time when the single edit fails
+	expected := sameRoles(roles, p.roles)
+	if !p.done {
+		expected = !slices.ContainsFunc(roles, func(roleID string) bool { return !slices.Contains(p.roles, roleID) })
+	}
+	if !expected {
+		delete(restoring, key)
+	}
+	return expected
+}
+
+// sameRoles reports whether two role lists hold the same roles, ignoring order
+func sameRoles(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+// restoreRoles gives a returning member their roles back in a single edit, falling back to one call
+// per role if that fails. The member's roles are stored once afterwards, intermediate updates are ignored.
+func restoreRoles(s *discordgo.Session, guildID string, member *discordgo.Member, roles []string) (restored, failed []string) {
+	userID := member.User.ID
+
+	desired := slices.Clone(member.Roles)
+	for _, roleID := range roles {
+		if !slices.Contains(desired, roleID) {
+			desired = append(desired, roleID)
+		}
+	}
+
+	pending := expectRestore(userID, guildID, desired)
+
+	final := member.Roles
+	updated, err := s.GuildMemberEdit(guildID, userID, &discordgo.GuildMemberParams{Roles: &desired})
+	if err == nil {
+		final = updated.Roles
+		for _, roleID := range roles {
+			if slices.Contains(final, roleID) {
+				restored = append(restored, roleID)
+			} else {
+				failed = append(failed, roleID)
+			}
+		}
+	} else {
+		log.Printf("Failed to restore roles for %s in one edit, adding them one by one: %v", member.User.Username, err)
+		final = slices.Clone(member.Roles)
+		for _, roleID := range roles {
+			if err := s.GuildMemberRoleAdd(guildID, userID, roleID); err != nil {
+				log.Printf("Failed to restore role %s for %s: %v", roleID, member.User.Username, err)
+				failed = append(failed, roleID)
+				continue
+			}
+			restored = append(restored, roleID)
+			final = append(final, roleID)
+		}
+	}
+
+	finishRestore(userID, guildID, pending, final)
+
+	// Roles that were skipped or failed stay stored, so a later restore can still give them back
+	stored, err := getStoredRoles(userID, guildID)
+	if err == nil {
+		for _, roleID := range final {
+			if !slices.Contains(stored, roleID) {
+				stored = append(stored, roleID)
+			}
+		}
+		err = storeRoles(userID, guildID, stored)
+	}
+	if err != nil {
+		log.Printf("Failed to update roles for %s: %v", member.User.Username, err)
+	}
+
+	if len(failed) > 0 {
+		reportFailedRestore(s, member.User, restored, failed)
+	}
+	return restored, failed
+}
+
+// reportFailedRestore tells the log channel which roles could not be given back
+func reportFailedRestore(s *discordgo.Session, user *discordgo.User, restored, failed []string) {
+	mentions := make([]string, len(failed))
+	for i, roleID := range failed {
+		mentions[i] = fmt.Sprintf("<@&%s>", roleID)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: "Sticky Roles Partially Restored",
+		Color: 0xffa500,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Member", Value: fmt.Sprintf("<@%s> (%s)", user.ID, user.Username), Inline: true},
+			{Name: "Restored", Value: fmt.Sprintf("%d roles", len(restored)), Inline: true},
+			{Name: "Failed", Value: strings.Join(mentions, ", "), Inline: false},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	_, _ = s.ChannelMessageSendEmbed(config.Config.LogChannelID, embed)
+}
@@ -0,0 +1,175 @@
+package sticky_roles
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/onboarding"
+	"time"
+)
+
+// userTables holds every table with data about a user
+var userTables = []string{"sticky_roles", "member_state", "joins", "member_events"}
+
+// deleteUser removes everything stored about a user within a transaction
+func deleteUser(tx *sql.Tx, userID, guildID string) error {
+	for _, table := range userTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ? AND guild_id = ?", userID, guildID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moderationRoles returns the stored moderation roles of a user
+func moderationRoles(userID, guildID string) ([]string, error) {
+	stored, err := getStoredRoles(userID, guildID)
+	if err != nil {
+		return nil, err
+	}
+	var kept []string
+	for _, roleID := range stored {
+		if slices.Contains(config.Config.StickyModerationRoleIDs, roleID) {
+			kept = append(kept, roleID)
+		}
+	}
+	return kept, nil
+}
+
+// forgetUser removes everything stored about a user within a transaction except their moderation
+// roles, which are kept along with the record of the join so they are restored on rejoin
+func forgetUser(tx *sql.Tx, userID, guildID string, kept []string) error {
+	if err := deleteUser(tx, userID, guildID); err != nil {
+		return err
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	if err := writeRoles(tx, userID, guildID, kept); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT OR IGNORE INTO joins (user_id, guild_id) VALUES (?, ?)", userID, guildID)
+	return err
+}
+
+// ErrTimedOut is returned when a member tries to delete their data while timed out
+var ErrTimedOut = errors.New("member is timed out")
+
+// DeleteUserData removes everything stored about a user on their own request, including their
+// join history. Moderation records are kept so leaving and rejoining still can't shed them:
+// it is refused during a timeout, and stored moderation roles are kept, see forgetUser.
+// Returns how many moderation roles were kept.
+func DeleteUserData(userID, guildID string) (int, error) {
+	state, err := getMemberState(userID, guildID)
+	if err != nil {
+		return 0, err
+	}
+	if state.TimeoutUntil.After(time.Now()) {
+		return 0, ErrTimedOut
+	}
+
+	kept, err := moderationRoles(userID, guildID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := forgetUser(tx, userID, guildID, kept); err != nil {
+		return 0, err
+	}
+	return len(kept), tx.Commit()
+}
+
+// PurgeDeparted removes everything stored about users who left before the cutoff and haven't come back,
+// except their moderation roles, see forgetUser. Returns the users who were forgotten completely.
+func PurgeDeparted(guildID string, cutoff time.Time) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT user_id FROM member_events WHERE event = ? AND created_at < ? AND id IN (
+			SELECT MAX(id) FROM member_events WHERE guild_id = ? GROUP BY user_id
+		)`,
+		eventLeave, cutoff.Unix(), guildID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+
+	kept := make(map[string][]string)
+	for _, userID := range userIDs {
+		if kept[userID], err = moderationRoles(userID, guildID); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var forgotten []string
+	for _, userID := range userIDs {
+		if err := forgetUser(tx, userID, guildID, kept[userID]); err != nil {
+			return nil, err
+		}
+		if len(kept[userID]) == 0 {
+			forgotten = append(forgotten, userID)
+		}
+	}
+
+	return forgotten, tx.Commit()
+}
+
+// StartRetention forgets departed members once a day according to StickyRetentionDays
+func StartRetention(guildID string) {
+	days := config.Config.StickyRetentionDays
+	if days <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	purge := func() {
+		forgotten, err := PurgeDeparted(guildID, time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			log.Printf("Failed to purge departed members: %v", err)
+		} else if len(forgotten) > 0 {
+			onboarding.ForgetDeparted(guildID, forgotten)
+			log.Printf("Forgot %d members who left more than %d days ago", len(forgotten), days)
+		}
+	}
+	purge()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-ticker.C:
+			purge()
+		case <-sigs:
+			return
+		}
+	}
+}
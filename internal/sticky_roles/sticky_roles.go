@@ -3,8 +3,7 @@ package sticky_roles
 import (
 	"database/sql"
 	"log"
-	"slices"
-	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/onboarding"
 
 	"github.com/bwmarrin/discordgo"
 	_ "github.com/mattn/go-sqlite3"
@@ -12,12 +11,10 @@ import (
 
 // OnMemberJoin handles re-joining members and assigns their previous roles or the default one.
 func OnMemberJoin(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
-	// Try to fetch stored roles from DB
-	oldRoles, err := getStoredRoles(m.User.ID, m.GuildID)
-	if err != nil {
-		log.Printf("DB error while fetching stored roles for %s: %v", m.User.Username, err)
-		return
+	if err := recordMemberEvent(m.User.ID, m.GuildID, eventJoin); err != nil {
+		log.Printf("DB error while recording join for %s: %v", m.User.Username, err)
 	}
+	postJoinLog(s, m)
 
 	// Check if user has joined before
 	joinedBefore, err := hasUserJoined(m.User.ID, m.GuildID)
@@ -31,28 +28,49 @@ func OnMemberJoin(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
 		if err != nil {
 			log.Printf("DB error while registering join for %s: %v", m.User.Username, err)
 		}
-		// First time join give default member role
-		if !hasRole(m.Member, config.Config.MemberRoleID) {
-			err := s.GuildMemberRoleAdd(m.GuildID, m.User.ID, config.Config.MemberRoleID)
-			if err != nil {
-				log.Printf("Failed to add default role to %s: %v", m.User.Username, err)
-			} else {
-				log.Printf("Assigned default role to new member: %s", m.User.Username)
-			}
-		}
+		// First time join give default roles
+		onboarding.OnFirstJoin(s, m.Member)
 		return
 	}
 
+	restoreReturning(s, m.GuildID, m.Member)
+}
+
+// restoreReturning gives a returning member their stored roles, nickname and timeout back,
+// and finishes onboarding for members who left before it was done
+func restoreReturning(s *discordgo.Session, guildID string, member *discordgo.Member) {
+	member.GuildID = guildID
+	onboarding.OnRejoin(s, member)
+
+	// Try to fetch stored roles from DB
+	oldRoles, err := getStoredRoles(member.User.ID, guildID)
+	if err != nil {
+		log.Printf("DB error while fetching stored roles for %s: %v", member.User.Username, err)
+		return
+	}
+
+	// Leave out privileged, managed and unassignable roles
+	if len(oldRoles) > 0 {
+		oldRoles, err = restorableRoles(s, guildID, member.User, oldRoles)
+		if err != nil {
+			log.Printf("Failed to check stored roles for %s: %v", member.User.Username, err)
+			oldRoles = nil
+		}
+	}
+
+	// Restore previous roles
 	if len(oldRoles) > 0 {
-		// Restore previous roles
-		for _, roleID := range oldRoles {
-			err := s.GuildMemberRoleAdd(m.GuildID, m.User.ID, roleID)
-			if err != nil {
-				log.Printf("Failed to restore role %s for %s: %v", roleID, m.User.Username, err)
-			}
+		restored, failed := restoreRoles(s, guildID, member, oldRoles)
+		if len(failed) > 0 {
+			log.Printf("Restored %d of %d roles for returning member %s, failed: %v", len(restored), len(oldRoles), member.User.Username, failed)
+		} else {
+			log.Printf("Restored %d roles for returning member: %s", len(restored), member.User.Username)
 		}
-		log.Printf("Restored %d roles for returning member: %s", len(oldRoles), m.User.Username)
 	}
+
+	// Leaving and rejoining must not shed a timeout or nickname. Done after the roles,
+	// so the member update it causes already carries them.
+	restoreMemberState(s, guildID, member)
 }
 
 // OnMemberUpdate is called whenever a member's roles are updated (add/remove).
@@ -61,8 +79,26 @@ func OnMemberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
 	guildID := m.GuildID
 	roles := m.Roles
 
+	// Restores store the final roles themselves
+	if fromRestore(userID, guildID, roles) {
+		return
+	}
+
+	if err := storeMemberState(userID, guildID, stateOf(m.Member)); err != nil {
+		log.Printf("Failed to update nickname and timeout for %s: %v", m.User.Username, err)
+	}
+
+	stored, err := getStoredRoles(userID, guildID)
+	if err != nil {
+		log.Printf("DB error while fetching stored roles for %s: %v", m.User.Username, err)
+		return
+	}
+	if sameRoles(stored, roles) {
+		return
+	}
+
 	// Store updated roles
-	err := storeRoles(userID, guildID, roles)
+	err = storeRoles(userID, guildID, roles)
 	if err != nil {
 		log.Printf("Failed to update roles for %s: %v", m.User.Username, err)
 	} else {
@@ -86,9 +122,13 @@ func OnRoleDelete(s *discordgo.Session, r *discordgo.GuildRoleDelete) {
 	}
 }
 
-// hasRole checks if a member already has a specific role.
-func hasRole(member *discordgo.Member, roleID string) bool {
-	return slices.Contains(member.Roles, roleID)
+// DropRole removes one role from a member's stored roles so it isn't restored when they join again
+func DropRole(userID, guildID, roleID string) error {
+	_, err := db.Exec(
+		"DELETE FROM sticky_roles WHERE user_id = ? AND guild_id = ? AND role_id = ?",
+		userID, guildID, roleID,
+	)
+	return err
 }
 
 var db *sql.DB
@@ -110,6 +150,22 @@ func InitDB(path string) error {
 		PRIMARY KEY (user_id, guild_id, role_id)
 	);
 	
+	CREATE TABLE IF NOT EXISTS member_state (
+		user_id       TEXT NOT NULL,
+		guild_id      TEXT NOT NULL,
+		nick          TEXT NOT NULL DEFAULT '',
+		timeout_until INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, guild_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS member_events (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    TEXT NOT NULL,
+		guild_id   TEXT NOT NULL,
+		event      TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
 	CREATE TABLE IF NOT EXISTS joins (
    		user_id  TEXT NOT NULL,
     	guild_id TEXT NOT NULL,
@@ -178,8 +234,17 @@ func storeRoles(userID, guildID string, roles []string) error {
 	}
 	defer tx.Rollback()
 
+	if err := writeRoles(tx, userID, guildID, roles); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeRoles replaces the stored roles of a user within a transaction.
+func writeRoles(tx *sql.Tx, userID, guildID string, roles []string) error {
 	// Clear old roles
-	_, err = tx.Exec(
+	_, err := tx.Exec(
 		"DELETE FROM sticky_roles WHERE user_id = ? AND guild_id = ?",
 		userID, guildID,
 	)
@@ -203,44 +268,5 @@ func storeRoles(userID, guildID string, roles []string) error {
 		}
 	}
 
-	return tx.Commit()
-}
-
-// SyncGuildRoles iterates through every member in a guild and saves their current roles.
-func SyncGuildRoles(s *discordgo.Session, guildID string) error {
-	after := "" // used for pagination
-	limit := 100
-
-	for {
-		members, err := s.GuildMembers(guildID, after, limit)
-		if err != nil {
-			return err
-		}
-		if len(members) == 0 {
-			break
-		}
-
-		for _, m := range members {
-			if len(m.Roles) == 0 {
-				continue
-			}
-			err := storeRoles(m.User.ID, guildID, m.Roles)
-			if err != nil {
-				log.Printf("Failed to sync roles for %s: %v", m.User.Username, err)
-			} else {
-				log.Printf("Synced roles for %s: %v", m.User.Username, m.Roles)
-			}
-		}
-
-		// Pagination: Discord API returns up to `limit` users after the given ID
-		after = members[len(members)-1].User.ID
-
-		// If fewer than limit returned, we reached the end
-		if len(members) < limit {
-			break
-		}
-	}
-
-	log.Printf("Finished syncing roles for guild %s", guildID)
 	return nil
 }
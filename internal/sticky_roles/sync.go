@@ -0,0 +1,338 @@
+package sticky_roles
+
+import (
+	"log"
+	"slices"
+	"teamacedia/discord-bot/internal/onboarding"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// syncBatchSize is how many changed members are written per transaction
+const syncBatchSize = 200
+
+// memberChange is a member whose stored data differs from what Discord reports
+type memberChange struct {
+	userID      string
+	roles       []string
+	state       memberState
+	rolesDiffer bool
+	stateDiffer bool
+}
+
+// loadGuildRoles returns the stored roles of every user in a guild
+func loadGuildRoles(guildID string) (map[string][]string, error) {
+	rows, err := db.Query("SELECT user_id, role_id FROM sticky_roles WHERE guild_id = ?", guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[string][]string)
+	for rows.Next() {
+		var userID, roleID string
+		if err := rows.Scan(&userID, &roleID); err != nil {
+			return nil, err
+		}
+		roles[userID] = append(roles[userID], roleID)
+	}
+	return roles, rows.Err()
+}
+
+// loadGuildStates returns the stored state of every user in a guild
+func loadGuildStates(guildID string) (map[string]memberState, error) {
+	rows, err := db.Query("SELECT user_id, nick, timeout_until FROM member_state WHERE guild_id = ?", guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]memberState)
+	for rows.Next() {
+		var userID string
+		var state memberState
+		var timeoutUntil int64
+		if err := rows.Scan(&userID, &state.Nick, &timeoutUntil); err != nil {
+			return nil, err
+		}
+		if timeoutUntil > 0 {
+			state.TimeoutUntil = time.Unix(timeoutUntil, 0)
+		}
+		states[userID] = state
+	}
+	return states, rows.Err()
+}
+
+// loadRegistered returns every user with a join record, including those from before events were recorded
+func loadRegistered(guildID string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT user_id FROM joins WHERE guild_id = ?", guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	registered := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		registered[userID] = true
+	}
+	return registered, rows.Err()
+}
+
+// loadPresence returns whether each user with recorded events was last seen joining (true) or leaving (false)
+func loadPresence(guildID string) (map[string]bool, error) {
+	rows, err := db.Query(
+		`SELECT user_id, event FROM member_events WHERE id IN (
+			SELECT MAX(id) FROM member_events WHERE guild_id = ? GROUP BY user_id
+		)`,
+		guildID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	present := make(map[string]bool)
+	for rows.Next() {
+		var userID, event string
+		if err := rows.Scan(&userID, &event); err != nil {
+			return nil, err
+		}
+		present[userID] = event == eventJoin
+	}
+	return present, rows.Err()
+}
+
+// lastEvent returns the id and time of the newest member event, zero if there are none
+func lastEvent(guildID string) (int64, time.Time, error) {
+	var id, createdAt int64
+	err := db.QueryRow(
+		"SELECT COALESCE(MAX(id), 0), COALESCE(MAX(created_at), 0) FROM member_events WHERE guild_id = ?",
+		guildID,
+	).Scan(&id, &createdAt)
+	if err != nil || createdAt == 0 {
+		return id, time.Time{}, err
+	}
+	return id, time.Unix(createdAt, 0), nil
+}
+
+// eventsSince returns the users with a member event newer than the given id
+func eventsSince(guildID string, id int64) (map[string]bool, error) {
+	rows, err := db.Query("SELECT DISTINCT user_id FROM member_events WHERE guild_id = ? AND id > ?", guildID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		users[userID] = true
+	}
+	return users, rows.Err()
+}
+
+// writeChanges stores changed members in batches, returning how many were written
+func writeChanges(guildID string, changes []memberChange) (int, error) {
+	written := 0
+	for start := 0; start < len(changes); start += syncBatchSize {
+		batch := changes[start:min(start+syncBatchSize, len(changes))]
+
+		tx, err := db.Begin()
+		if err != nil {
+			return written, err
+		}
+		for _, c := range batch {
+			if c.rolesDiffer {
+				err = writeRoles(tx, c.userID, guildID, c.roles)
+			}
+			if err == nil && c.stateDiffer {
+				err = writeMemberState(tx, c.userID, guildID, c.state)
+			}
+			if err != nil {
+				tx.Rollback()
+				return written, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return written, err
+		}
+		written += len(batch)
+	}
+	return written, nil
+}
+
+// presenceChange is a join or leave that happened while the bot was offline
+type presenceChange struct {
+	userID string
+	event  string
+	at     time.Time
+	first  bool // a first join, which also gets a join record
+}
+
+// recordPresenceChanges stores the joins and leaves that happened while the bot was offline
+func recordPresenceChanges(guildID string, changes []presenceChange) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, c := range changes {
+		_, err := tx.Exec(
+			"INSERT INTO member_events (user_id, guild_id, event, created_at) VALUES (?, ?, ?, ?)",
+			c.userID, guildID, c.event, c.at.Unix(),
+		)
+		if err != nil {
+			return err
+		}
+		if !c.first {
+			continue
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO joins (user_id, guild_id) VALUES (?, ?)", c.userID, guildID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// joinedAt returns when a member joined, or now if Discord didn't say
+func joinedAt(m *discordgo.Member) time.Time {
+	if m.JoinedAt.IsZero() {
+		return time.Now()
+	}
+	return m.JoinedAt
+}
+
+// SyncGuildRoles compares every member of a guild with the stored data and only writes what changed.
+// Users who left or joined while the bot was offline get a leave or join event, and are restored
+// or onboarded like they would have been when online. Members from before join history was
+// recorded are registered with their join date, but not onboarded again.
+func SyncGuildRoles(s *discordgo.Session, guildID string) error {
+	storedRoles, err := loadGuildRoles(guildID)
+	if err != nil {
+		return err
+	}
+	storedStates, err := loadGuildStates(guildID)
+	if err != nil {
+		return err
+	}
+	registered, err := loadRegistered(guildID)
+	if err != nil {
+		return err
+	}
+	presence, err := loadPresence(guildID)
+	if err != nil {
+		return err
+	}
+	// Joins and leaves after this are handled by the live handlers
+	lastID, lastAt, err := lastEvent(guildID)
+	if err != nil {
+		return err
+	}
+
+	var changes []memberChange
+	var offline []presenceChange
+	var rejoined, newcomers []*discordgo.Member
+	seen := make(map[string]bool)
+	checked := 0
+
+	after := "" // used for pagination
+	limit := 1000
+
+	for {
+		members, err := s.GuildMembers(guildID, after, limit)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range members {
+			checked++
+			userID := m.User.ID
+			seen[userID] = true
+			m.GuildID = guildID
+
+			present, known := presence[userID]
+			switch {
+			case known && !present && registered[userID]:
+				// Members who left and came back while we were offline get the rejoin treatment,
+				// writing their current roles and state would wipe what they left with
+				offline = append(offline, presenceChange{userID: userID, event: eventJoin, at: joinedAt(m)})
+				rejoined = append(rejoined, m)
+				continue
+
+			case !registered[userID] && !present:
+				// Only members who joined after the newest event are certain to have joined while we
+				// were offline, older ones are from before join history was recorded
+				offline = append(offline, presenceChange{userID: userID, event: eventJoin, at: joinedAt(m), first: true})
+				if known || (!lastAt.IsZero() && joinedAt(m).After(lastAt)) {
+					newcomers = append(newcomers, m)
+				}
+			}
+
+			c := memberChange{userID: userID, roles: m.Roles, state: stateOf(m)}
+			c.rolesDiffer = !sameRoles(storedRoles[userID], m.Roles)
+			c.stateDiffer = !sameState(storedStates[userID], c.state)
+			if c.rolesDiffer || c.stateDiffer {
+				changes = append(changes, c)
+			}
+		}
+
+		// If fewer than limit returned, we reached the end
+		if len(members) < limit {
+			break
+		}
+		// Pagination: Discord API returns up to `limit` users after the given ID
+		after = members[len(members)-1].User.ID
+	}
+
+	// Anyone last seen joining who isn't in the guild anymore left while we were offline. Members
+	// from before join history was recorded have no join to end, so they get no leave either.
+	now := time.Now()
+	departed := 0
+	for userID, present := range presence {
+		if present && !seen[userID] {
+			offline = append(offline, presenceChange{userID: userID, event: eventLeave, at: now})
+			departed++
+		}
+	}
+
+	written, err := writeChanges(guildID, changes)
+	if err != nil {
+		return err
+	}
+	unchanged := checked - written - len(rejoined)
+
+	// Members who joined or left during the sync were already handled by the live handlers
+	live, err := eventsSince(guildID, lastID)
+	if err != nil {
+		return err
+	}
+	offline = slices.DeleteFunc(offline, func(c presenceChange) bool { return live[c.userID] })
+	rejoined = slices.DeleteFunc(rejoined, func(m *discordgo.Member) bool { return live[m.User.ID] })
+	newcomers = slices.DeleteFunc(newcomers, func(m *discordgo.Member) bool { return live[m.User.ID] })
+
+	if err := recordPresenceChanges(guildID, offline); err != nil {
+		return err
+	}
+
+	for _, m := range rejoined {
+		restoreReturning(s, guildID, m)
+	}
+	for _, m := range newcomers {
+		onboarding.OnFirstJoin(s, m)
+	}
+
+	log.Printf("Synced guild %s: %d members checked, %d updated, %d unchanged, %d marked as departed, %d rejoined and %d joined while offline",
+		guildID, checked, written, unchanged, departed, len(rejoined), len(newcomers))
+	return nil
+}
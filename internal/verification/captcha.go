@@ -0,0 +1,156 @@
+package verification
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"teamacedia/discord-bot/internal/imaging"
+	"unicode"
+)
+
+const (
+	codeLength = 6
+	codeScale  = 6 // pixels per font pixel
+	codePad    = 20
+
+	// Letters and digits that are hard to tell apart, like O and 0, are left out
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// Challenge is a captcha shown to a member. Image is a PNG for image captchas and empty for text captchas.
+type Challenge struct {
+	Prompt string
+	Image  []byte
+	answer string
+}
+
+// randomCode returns a code of codeLength characters from codeAlphabet
+func randomCode() string {
+	code := make([]byte, codeLength)
+	for i := range code {
+		code[i] = codeAlphabet[rand.IntN(len(codeAlphabet))]
+	}
+	return string(code)
+}
+
+// newImageChallenge draws a random code on a noisy background
+func newImageChallenge() (Challenge, error) {
+	code := randomCode()
+
+	img, err := renderCode(code)
+	if err != nil {
+		return Challenge{}, err
+	}
+	return Challenge{
+		Prompt: "Type the code shown in the picture",
+		Image:  img,
+		answer: code,
+	}, nil
+}
+
+// textTasks are the ways a text captcha can ask for a random code to be rewritten.
+// Prompts are shown as the label of the answer input, which fits at most 45 characters.
+var textTasks = []struct {
+	prompt string
+	answer func(code string) string
+}{
+	{"Type %s backwards", func(code string) string {
+		reversed := []byte(code)
+		slices.Reverse(reversed)
+		return string(reversed)
+	}},
+	{"Type only the letters of %s", func(code string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) {
+				return r
+			}
+			return -1
+		}, code)
+	}},
+	{"Type every second character of %s", func(code string) string {
+		var every []byte
+		for i := 1; i < len(code); i += 2 {
+			every = append(every, code[i])
+		}
+		return string(every)
+	}},
+}
+
+// newTextChallenge asks for a random code to be rewritten in one of several ways, so the answer
+// can't be copied from the prompt and is too unlikely to be guessed
+func newTextChallenge() Challenge {
+	task := textTasks[rand.IntN(len(textTasks))]
+
+	// Codes with too few letters would have a short, easily guessed answer
+	code, answer := "", ""
+	for len(answer) < 3 {
+		code = randomCode()
+		answer = task.answer(code)
+	}
+	return Challenge{
+		Prompt: fmt.Sprintf(task.prompt, code),
+		answer: answer,
+	}
+}
+
+// renderCode draws code as a PNG with jittered, differently colored characters and noise lines
+func renderCode(code string) ([]byte, error) {
+	width := imaging.TextWidth(code, codeScale) + 2*codePad
+	height := imaging.GlyphHeight*codeScale + 2*codePad
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	background := color.RGBA{0xf2, 0xf3, 0xf5, 0xff}
+	imaging.Fill(img, background, background)
+
+	// Speckles behind the text
+	for range width * height / 12 {
+		img.Set(rand.IntN(width), rand.IntN(height), randomColor(120, 220))
+	}
+
+	x := codePad
+	for _, r := range code {
+		y := codePad + rand.IntN(codePad) - codePad/2
+		imaging.DrawGlyph(img, x+rand.IntN(5)-2, y, r, codeScale, randomColor(0, 110))
+		x += (imaging.GlyphWidth + 1) * codeScale
+	}
+
+	// Lines across the text
+	for range 5 {
+		drawLine(img, 0, rand.IntN(height), width-1, rand.IntN(height), randomColor(0, 160))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// randomColor returns an opaque color with every channel between lo and hi
+func randomColor(lo, hi int) color.RGBA {
+	channel := func() uint8 { return uint8(lo + rand.IntN(hi-lo)) }
+	return color.RGBA{channel(), channel(), channel(), 0xff}
+}
+
+// drawLine draws a two pixel thick line between two points
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	steps := max(abs(x1-x0), abs(y1-y0))
+	for i := 0; i <= steps; i++ {
+		x := x0 + (x1-x0)*i/steps
+		y := y0 + (y1-y0)*i/steps
+		img.Set(x, y, c)
+		img.Set(x, y+1, c)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
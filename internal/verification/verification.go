@@ -0,0 +1,305 @@
+package verification
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/db"
+	"teamacedia/discord-bot/internal/models"
+	"teamacedia/discord-bot/internal/onboarding"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Component and modal IDs
+const (
+	Prefix   = "verify:"
+	StartID  = Prefix + "start"  // Verify button on the panel
+	AnswerID = Prefix + "answer" // button under an image captcha that opens the answer modal
+	SubmitID = Prefix + "submit" // answer modal
+	InputID  = "answer"          // text input in the answer modal
+)
+
+const (
+	// challengeTTL is how long a captcha can be answered
+	challengeTTL = 10 * time.Minute
+
+	// challengeCooldown is how long a member has to wait between two captchas
+	challengeCooldown = 10 * time.Second
+
+	// maxAttempts is how many wrong answers a member can give before they are kicked
+	maxAttempts = 5
+)
+
+var (
+	// ErrNoChallenge is returned when a member answers without a captcha, or after it expired
+	ErrNoChallenge = errors.New("your captcha expired, press Verify for a new one")
+
+	// ErrCooldown is returned when a member asks for a new captcha too soon after the last one
+	ErrCooldown = errors.New("please wait a few seconds before asking for a new captcha")
+
+	// ErrTooManyAttempts is returned once a member has given maxAttempts wrong answers
+	ErrTooManyAttempts = errors.New("too many wrong answers")
+)
+
+type pendingChallenge struct {
+	answer  string
+	expires time.Time
+}
+
+var (
+	challenges   = map[string]pendingChallenge{} // by user ID
+	issued       = map[string]time.Time{}        // when each member last got a captcha
+	challengesMu sync.Mutex
+)
+
+// Enabled reports whether new members have to verify
+func Enabled() bool {
+	return config.Config.VerificationChannelID != ""
+}
+
+// IsPending reports whether a member still has to verify
+func IsPending(userID, guildID string) (bool, error) {
+	_, err := db.GetVerification(userID, guildID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// NewChallenge creates a captcha for a member, replacing any earlier one. Members have to wait
+// challengeCooldown between captchas, and get none once they ran out of attempts but couldn't be kicked.
+func NewChallenge(guildID, userID string) (Challenge, error) {
+	v, err := db.GetVerification(userID, guildID)
+	if err != nil {
+		return Challenge{}, err
+	}
+	if v.Attempts >= maxAttempts {
+		return Challenge{}, ErrTooManyAttempts
+	}
+
+	challengesMu.Lock()
+	now := time.Now()
+	for id, at := range issued {
+		if now.Sub(at) > challengeCooldown {
+			delete(issued, id)
+		}
+	}
+	if _, ok := issued[userID]; ok {
+		challengesMu.Unlock()
+		return Challenge{}, ErrCooldown
+	}
+	issued[userID] = now
+	challengesMu.Unlock()
+
+	var c Challenge
+	if config.Config.VerificationCaptcha == models.CaptchaText {
+		c = newTextChallenge()
+	} else {
+		c, err = newImageChallenge()
+		if err != nil {
+			return c, err
+		}
+	}
+
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+	challenges[userID] = pendingChallenge{answer: c.answer, expires: now.Add(challengeTTL)}
+	return c, nil
+}
+
+// takeChallenge returns the answer to a member's captcha, which can only be answered once
+func takeChallenge(userID string) (string, bool) {
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+
+	// Drop captchas nobody answered
+	now := time.Now()
+	for id, c := range challenges {
+		if now.After(c.expires) {
+			delete(challenges, id)
+		}
+	}
+
+	c, ok := challenges[userID]
+	delete(challenges, userID)
+	return c.answer, ok
+}
+
+// Check compares a member's answer with their captcha. The right answer lets them in, a wrong
+// one is counted and logged, and they have to start over with a new captcha. After maxAttempts
+// wrong answers the member is kicked and ErrTooManyAttempts is returned.
+func Check(s *discordgo.Session, guildID string, user *discordgo.User, answer string) (bool, error) {
+	expected, ok := takeChallenge(user.ID)
+	if !ok {
+		return false, ErrNoChallenge
+	}
+
+	if !strings.EqualFold(strings.Join(strings.Fields(answer), ""), expected) {
+		attempts, err := db.AddVerificationAttempt(user.ID, guildID)
+		if err != nil {
+			return false, err
+		}
+		log.Printf("%s failed verification (attempt %d of %d)", user.Username, attempts, maxAttempts)
+		if attempts < maxAttempts {
+			postLog(s, "Verification Failed", 0xffa500, user, fmt.Sprintf("Wrong answer, attempt %d of %d", attempts, maxAttempts))
+			return false, nil
+		}
+
+		// Members who can't be kicked stay locked out, see NewChallenge
+		result := fmt.Sprintf("Kicked after %d wrong answers", attempts)
+		if err := s.GuildMemberDeleteWithReason(guildID, user.ID, "Failed verification too many times"); err != nil {
+			log.Printf("Failed to kick %s after failing verification: %v", user.Username, err)
+			result = fmt.Sprintf("Locked out after %d wrong answers, kicking failed: %v", attempts, err)
+		} else if err := db.SetVerificationDeparted(user.ID, guildID); err != nil {
+			log.Printf("Failed to update verification of %s: %v", user.Username, err)
+		}
+		postLog(s, "Verification Failed", 0xff0000, user, result)
+		return false, ErrTooManyAttempts
+	}
+
+	if err := db.DeleteVerification(user.ID, guildID); err != nil {
+		return false, err
+	}
+	log.Printf("%s passed verification", user.Username)
+	postLog(s, "Member Verified", 0x00ff00, user, "Passed the captcha")
+
+	onboarding.OnVerified(s, guildID, user)
+	return true, nil
+}
+
+// OnMemberLeave keeps the pending verification of members who leave before verifying,
+// so they have to verify when they come back, but stops their deadline
+func OnMemberLeave(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	if err := db.SetVerificationDeparted(m.User.ID, m.GuildID); err != nil {
+		log.Printf("Failed to update verification of %s: %v", m.User.Username, err)
+	}
+}
+
+// postLog posts a verification entry to the log channel
+func postLog(s *discordgo.Session, title string, color int, user *discordgo.User, result string) {
+	embed := &discordgo.MessageEmbed{
+		Title: title,
+		Color: color,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Member", Value: fmt.Sprintf("<@%s> (%s)", user.ID, user.Username), Inline: true},
+			{Name: "Result", Value: result, Inline: true},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	_, _ = s.ChannelMessageSendEmbed(config.Config.LogChannelID, embed)
+}
+
+// EnsurePanel posts the message with the Verify button, unless it is already among the channel's recent messages
+func EnsurePanel(s *discordgo.Session) error {
+	messages, err := s.ChannelMessages(config.Config.VerificationChannelID, 50, "", "", "")
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if msg.Author != nil && msg.Author.ID == s.State.User.ID && hasStartButton(msg) {
+			return nil
+		}
+	}
+
+	_, err = s.ChannelMessageSendComplex(config.Config.VerificationChannelID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       "Verification",
+			Description: "Welcome! Press **Verify** and solve the captcha to get access to the rest of the server.",
+			Color:       0x00FFFF, // Cyan
+		}},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "Verify", Style: discordgo.SuccessButton, CustomID: StartID},
+			}},
+		},
+	})
+	return err
+}
+
+// hasStartButton reports whether a message carries the Verify button
+func hasStartButton(msg *discordgo.Message) bool {
+	for _, component := range msg.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, c := range row.Components {
+			if button, ok := c.(*discordgo.Button); ok && button.CustomID == StartID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StartDeadline kicks members who didn't verify within VerificationTimeout
+func StartDeadline(s *discordgo.Session) {
+	if config.Config.VerificationTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	kickUnverified(s)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-ticker.C:
+			kickUnverified(s)
+		case <-sigs:
+			return
+		}
+	}
+}
+
+// kickUnverified kicks every member whose verification deadline passed
+func kickUnverified(s *discordgo.Session) {
+	overdue, err := db.GetVerificationsJoinedBefore(time.Now().Add(-config.Config.VerificationTimeout))
+	if err != nil {
+		log.Printf("Failed to load pending verifications: %v", err)
+		return
+	}
+
+	for _, v := range overdue {
+		user := &discordgo.User{ID: v.UserID, Username: v.UserID}
+		if member, err := s.GuildMember(v.GuildID, v.UserID); err == nil {
+			user = member.User
+		}
+
+		err := s.GuildMemberDeleteWithReason(v.GuildID, v.UserID, "Did not verify in time")
+
+		// Members who already left only need their deadline stopped
+		var restErr *discordgo.RESTError
+		if err != nil && !(errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound) {
+			log.Printf("Failed to kick unverified member %s: %v", user.Username, err)
+			continue
+		}
+		if err == nil {
+			result := "Kicked without answering a captcha"
+			if v.Attempts > 0 {
+				result = fmt.Sprintf("Kicked after %d wrong answers", v.Attempts)
+			}
+			log.Printf("Kicked %s for not verifying in time", user.Username)
+			postLog(s, "Verification Timed Out", 0xff0000, user, result)
+		}
+
+		if err := db.SetVerificationDeparted(v.UserID, v.GuildID); err != nil {
+			log.Printf("Failed to update verification of %s: %v", user.Username, err)
+		}
+	}
+}
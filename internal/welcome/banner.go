@@ -0,0 +1,103 @@
+package welcome
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"teamacedia/discord-bot/internal/imaging"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Banner layout in pixels
+const (
+	bannerWidth  = 800
+	bannerHeight = 240
+	avatarSize   = 160
+	avatarX      = 40
+	avatarY      = (bannerHeight - avatarSize) / 2
+	ringWidth    = 5
+	textX        = avatarX + avatarSize + 40
+	nameScale    = 4
+)
+
+var (
+	bannerTop    = color.RGBA{0x1e, 0x1f, 0x22, 0xff}
+	bannerBottom = color.RGBA{0x31, 0x33, 0x38, 0xff}
+	accent       = color.RGBA{0x00, 0xff, 0xff, 0xff} // Cyan, like the bot's embeds
+	textColor    = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	subtleColor  = color.RGBA{0xb5, 0xba, 0xc1, 0xff}
+)
+
+// renderBanner draws a welcome picture with the member's avatar and name as a PNG
+func renderBanner(s *discordgo.Session, user *discordgo.User, memberCount int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, bannerWidth, bannerHeight))
+	imaging.Fill(img, bannerTop, bannerBottom)
+
+	// Accent strip along the left edge
+	for y := 0; y < bannerHeight; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, accent)
+		}
+	}
+
+	// Avatar in a ring, or the first letter of the name if it can't be fetched
+	r := avatarSize / 2
+	imaging.FillCircle(img, avatarX+r, avatarY+r, r+ringWidth, accent)
+	avatar, err := fetchAvatar(s, user)
+	if err != nil {
+		log.Printf("Failed to fetch avatar of %s, drawing a placeholder: %v", user.Username, err)
+		imaging.FillCircle(img, avatarX+r, avatarY+r, r, bannerBottom)
+		initial := string([]rune(displayName(user))[:1])
+		scale := 12
+		imaging.DrawText(img, avatarX+r-imaging.TextWidth(initial, scale)/2, avatarY+r-imaging.GlyphHeight*scale/2, initial, scale, textColor)
+	} else {
+		imaging.DrawCircleImage(img, avatar, avatarX, avatarY, avatarSize)
+	}
+
+	imaging.DrawText(img, textX, 50, "WELCOME", 7, accent)
+	imaging.DrawText(img, textX, 120, fitName(displayName(user)), nameScale, textColor)
+	if memberCount > 0 {
+		imaging.DrawText(img, textX, 170, fmt.Sprintf("MEMBER %d", memberCount), 3, subtleColor)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fetchAvatar downloads the user's avatar, or Discord's default avatar if they have none
+func fetchAvatar(s *discordgo.Session, user *discordgo.User) (image.Image, error) {
+	if user.Avatar != "" {
+		return s.UserAvatarDecode(user)
+	}
+
+	resp, err := s.Client.Get(user.AvatarURL(""))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	img, _, err := image.Decode(resp.Body)
+	return img, err
+}
+
+// fitName shortens a name so it fits on the banner next to the avatar
+func fitName(name string) string {
+	maxChars := (bannerWidth - textX - 30) / ((imaging.GlyphWidth + 1) * nameScale)
+	runes := []rune(strings.ToUpper(name))
+	if len(runes) <= maxChars {
+		return string(runes)
+	}
+	return string(runes[:maxChars-3]) + "..."
+}
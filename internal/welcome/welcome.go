@@ -0,0 +1,140 @@
+package welcome
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"teamacedia/discord-bot/internal/config"
+	"teamacedia/discord-bot/internal/db"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// OnMemberJoin welcomes a new member. With verification on, members are welcomed once they verify instead.
+func OnMemberJoin(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if config.Config.VerificationChannelID != "" {
+		return
+	}
+	greet(s, m.GuildID, m.User)
+}
+
+// OnVerified welcomes a member who just passed verification
+func OnVerified(s *discordgo.Session, guildID string, user *discordgo.User) {
+	greet(s, guildID, user)
+}
+
+// greet welcomes a member in the welcome channel and, if configured, by DM
+func greet(s *discordgo.Session, guildID string, user *discordgo.User) {
+	if user.Bot || (config.Config.WelcomeChannelID == "" && config.Config.WelcomeDM == "") {
+		return
+	}
+
+	guildName, memberCount := guildInfo(s, guildID)
+
+	var banner []byte
+	if config.Config.WelcomeBanner {
+		var err error
+		banner, err = renderBanner(s, user, memberCount)
+		if err != nil {
+			log.Printf("Failed to render welcome banner for %s: %v", user.Username, err)
+		}
+	}
+
+	if config.Config.WelcomeChannelID != "" {
+		content := render(config.Config.WelcomeMessage, user, guildName, memberCount)
+		if _, err := s.ChannelMessageSendComplex(config.Config.WelcomeChannelID, welcomeMessage(content, banner, user.ID)); err != nil {
+			log.Printf("Failed to post welcome message for %s: %v", user.Username, err)
+		}
+	}
+
+	if config.Config.WelcomeDM != "" {
+		channel, err := s.UserChannelCreate(user.ID)
+		if err != nil {
+			log.Printf("Failed to open DM with %s: %v", user.Username, err)
+			return
+		}
+		content := render(config.Config.WelcomeDM, user, guildName, memberCount)
+
+		// Members who don't accept DMs from server members can't be reached, which is fine
+		if _, err := s.ChannelMessageSendComplex(channel.ID, welcomeMessage(content, banner, user.ID)); err != nil {
+			log.Printf("Failed to send welcome DM to %s: %v", user.Username, err)
+		}
+	}
+}
+
+// OnMemberLeave says goodbye in the goodbye channel, except to members who never verified
+func OnMemberLeave(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	if m.User.Bot || config.Config.GoodbyeChannelID == "" {
+		return
+	}
+	if _, err := db.GetVerification(m.User.ID, m.GuildID); err == nil {
+		return
+	}
+
+	guildName, memberCount := guildInfo(s, m.GuildID)
+	content := render(config.Config.GoodbyeMessage, m.User, guildName, memberCount)
+	if _, err := s.ChannelMessageSendComplex(config.Config.GoodbyeChannelID, &discordgo.MessageSend{
+		Content:         content,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}); err != nil {
+		log.Printf("Failed to post goodbye message for %s: %v", m.User.Username, err)
+	}
+}
+
+// welcomeMessage builds a welcome message, with the banner attached if there is one.
+// Only the new member is pinged, even if the template mentions others.
+func welcomeMessage(content string, banner []byte, userID string) *discordgo.MessageSend {
+	msg := &discordgo.MessageSend{
+		Content:         content,
+		AllowedMentions: &discordgo.MessageAllowedMentions{Users: []string{userID}},
+	}
+	if len(banner) > 0 {
+		msg.Files = []*discordgo.File{{
+			Name:        "welcome.png",
+			ContentType: "image/png",
+			Reader:      bytes.NewReader(banner),
+		}}
+	}
+	return msg
+}
+
+// guildInfo returns the guild's name and member count, from the state if possible
+func guildInfo(s *discordgo.Session, guildID string) (string, int) {
+	if guild, err := s.State.Guild(guildID); err == nil && guild.MemberCount > 0 {
+		return guild.Name, guild.MemberCount
+	}
+
+	guild, err := s.GuildWithCounts(guildID)
+	if err != nil {
+		log.Printf("Failed to fetch guild %s: %v", guildID, err)
+		return "the server", 0
+	}
+	return guild.Name, guild.ApproximateMemberCount
+}
+
+// render fills in the placeholders of a welcome or goodbye template
+func render(template string, user *discordgo.User, guildName string, memberCount int) string {
+	rules := "the rules"
+	if config.Config.RulesChannelID != "" {
+		rules = fmt.Sprintf("<#%s>", config.Config.RulesChannelID)
+	}
+
+	return strings.NewReplacer(
+		`\n`, "\n",
+		"{user}", user.Mention(),
+		"{username}", displayName(user),
+		"{server}", guildName,
+		"{member_count}", strconv.Itoa(memberCount),
+		"{rules_channel}", rules,
+	).Replace(template)
+}
+
+// displayName returns the user's global display name, or their username if they have none
+func displayName(user *discordgo.User) string {
+	if user.GlobalName != "" {
+		return user.GlobalName
+	}
+	return user.Username
+}